@@ -1,18 +1,132 @@
 package brokertags
 
+import "fmt"
+
 // Action - Custom type to hold value for broker action
 type Action int
 
 const (
-	createdAtTagKey = "Created at"
-	updatedAtTagKey = "Updated at"
+	createdAtTagKey  = "Created at"
+	updatedAtTagKey  = "Updated at"
+	boundAtTagKey    = "Bound at"
+	unboundAtTagKey  = "Unbound at"
+	deletedAtTagKey  = "Deleted at"
+	restoredAtTagKey = "Restored at"
+
+	createdByTagKey  = "Created by"
+	updatedByTagKey  = "Updated by"
+	boundByTagKey    = "Bound by"
+	unboundByTagKey  = "Unbound by"
+	deletedByTagKey  = "Deleted by"
+	restoredByTagKey = "Restored by"
 )
 
 const (
-	Create Action = iota // EnumIndex = 0
-	Update               // EnumIndex = 1
+	Create  Action = iota // EnumIndex = 0
+	Update                // EnumIndex = 1
+	Bind                  // EnumIndex = 2
+	Unbind                // EnumIndex = 3
+	Delete                // EnumIndex = 4
+	Restore               // EnumIndex = 5
 )
 
-func (a Action) getTagKey() string {
-	return [...]string{createdAtTagKey, updatedAtTagKey}[a]
+var actionNames = [...]string{"create", "update", "bind", "unbind", "delete", "restore"}
+
+var actionTagKeys = [...]string{
+	createdAtTagKey,
+	updatedAtTagKey,
+	boundAtTagKey,
+	unboundAtTagKey,
+	deletedAtTagKey,
+	restoredAtTagKey,
+}
+
+var actionIdentityTagKeys = [...]string{
+	createdByTagKey,
+	updatedByTagKey,
+	boundByTagKey,
+	unboundByTagKey,
+	deletedByTagKey,
+	restoredByTagKey,
+}
+
+// timestampTagKeys are the keys of the action timestamp tags. They record
+// when each action last happened, so they are kept even when the most recent
+// action did not generate them.
+var timestampTagKeys = map[string]bool{
+	createdAtTagKey:  true,
+	updatedAtTagKey:  true,
+	boundAtTagKey:    true,
+	unboundAtTagKey:  true,
+	deletedAtTagKey:  true,
+	restoredAtTagKey: true,
+}
+
+// identityTagKeys are the keys of the tags recording who last did each
+// action. Like the timestamp tags, they are kept even when the most recent
+// action did not generate them.
+var identityTagKeys = map[string]bool{
+	createdByTagKey:  true,
+	updatedByTagKey:  true,
+	boundByTagKey:    true,
+	unboundByTagKey:  true,
+	deletedByTagKey:  true,
+	restoredByTagKey: true,
+}
+
+// ParseAction returns the Action with the given name, such as "create".
+func ParseAction(name string) (Action, error) {
+	for i, actionName := range actionNames {
+		if name == actionName {
+			return Action(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown action: %q", name)
+}
+
+func (a Action) String() string {
+	if !a.isValid() {
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+	return actionNames[a]
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	if !a.isValid() {
+		return nil, fmt.Errorf("unknown action: %d", int(a))
+	}
+	return []byte(actionNames[a]), nil
+}
+
+func (a *Action) UnmarshalText(text []byte) error {
+	action, err := ParseAction(string(text))
+	if err != nil {
+		return err
+	}
+	*a = action
+	return nil
+}
+
+func (a Action) isValid() bool {
+	return a >= 0 && int(a) < len(actionNames)
+}
+
+// instanceExists reports whether the service instance already exists in CF
+// when the action happens.
+func (a Action) instanceExists() bool {
+	return a != Create
+}
+
+func (a Action) getTagKey() (string, error) {
+	if !a.isValid() {
+		return "", fmt.Errorf("unknown action: %d", int(a))
+	}
+	return actionTagKeys[a], nil
+}
+
+func (a Action) getIdentityTagKey() (string, error) {
+	if !a.isValid() {
+		return "", fmt.Errorf("unknown action: %d", int(a))
+	}
+	return actionIdentityTagKeys[a], nil
 }
@@ -1,11 +1,16 @@
 package brokertags
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
 
 func TestCreateActionGetTagKey(t *testing.T) {
 	testCases := map[string]struct {
 		action         Action
 		expectedTagKey string
+		expectedErr    error
 	}{
 		"Create": {
 			action:         Create,
@@ -15,13 +20,106 @@ func TestCreateActionGetTagKey(t *testing.T) {
 			action:         Update,
 			expectedTagKey: "Updated at",
 		},
+		"Bind": {
+			action:         Bind,
+			expectedTagKey: "Bound at",
+		},
+		"Unbind": {
+			action:         Unbind,
+			expectedTagKey: "Unbound at",
+		},
+		"Delete": {
+			action:         Delete,
+			expectedTagKey: "Deleted at",
+		},
+		"Restore": {
+			action:         Restore,
+			expectedTagKey: "Restored at",
+		},
+		"unknown": {
+			action:      Action(42),
+			expectedErr: errors.New("unknown action: 42"),
+		},
+		"negative": {
+			action:      Action(-1),
+			expectedErr: errors.New("unknown action: -1"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagKey, err := test.action.getTagKey()
+			if (test.expectedErr != nil && err == nil) ||
+				(err != nil && err.Error() != test.expectedErr.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if tagKey != test.expectedTagKey {
+				t.Errorf("expected tag key: %s, got: %s", test.expectedTagKey, tagKey)
+			}
+		})
+	}
+}
+
+func TestActionString(t *testing.T) {
+	testCases := map[string]struct {
+		action         Action
+		expectedString string
+	}{
+		"Create": {
+			action:         Create,
+			expectedString: "create",
+		},
+		"Restore": {
+			action:         Restore,
+			expectedString: "restore",
+		},
+		"unknown": {
+			action:         Action(42),
+			expectedString: "Action(42)",
+		},
 	}
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			if test.action.getTagKey() != test.expectedTagKey {
-				t.Errorf("expected tag key: %s, got: %s", test.expectedTagKey, test.action.getTagKey())
+			if test.action.String() != test.expectedString {
+				t.Errorf("expected string: %s, got: %s", test.expectedString, test.action.String())
 			}
 		})
 	}
 }
+
+func TestActionMarshalText(t *testing.T) {
+	for _, action := range []Action{Create, Update, Bind, Unbind, Delete, Restore} {
+		t.Run(action.String(), func(t *testing.T) {
+			text, err := json.Marshal(action)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var unmarshaled Action
+			if err := json.Unmarshal(text, &unmarshaled); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if unmarshaled != action {
+				t.Errorf("expected action: %s, got: %s", action, unmarshaled)
+			}
+		})
+	}
+
+	if _, err := Action(42).MarshalText(); err == nil {
+		t.Errorf("expected an error marshaling an unknown action")
+	}
+	var action Action
+	if err := action.UnmarshalText([]byte("destroy")); err == nil || err.Error() != `unknown action: "destroy"` {
+		t.Errorf("expected error unmarshaling an unknown action, got: %s", err)
+	}
+}
+
+func TestGenerateTagsUnknownAction(t *testing.T) {
+	tagManager := &CfTagManager{
+		cfResourceGetter: &mockCFClientWrapper{},
+	}
+	_, err := tagManager.GenerateTags(Action(42), "abc1", "abc2", ResourceGUIDs{}, false)
+	if err == nil || err.Error() != "unknown action: 42" {
+		t.Fatalf("expected error for unknown action, got: %s", err)
+	}
+}
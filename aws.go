@@ -0,0 +1,107 @@
+package brokertags
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	awsMaxTags           = 50
+	awsMaxKeyLength      = 128
+	awsMaxValueLength    = 256
+	awsReservedKeyPrefix = "aws:"
+)
+
+// awsInvalidCharacters matches characters that AWS does not allow in tag keys
+// and values. AWS allows letters, numbers and spaces representable in UTF-8,
+// and the characters _ . : / = + - @
+var awsInvalidCharacters = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+// AWSTag is a single key/value tag as accepted by the AWS APIs.
+type AWSTag struct {
+	Key   string
+	Value string
+}
+
+// AWSTagChange describes an adjustment FormatAWSTags made to a tag so that
+// AWS would accept it. Key is the key of the tag before any changes.
+type AWSTagChange struct {
+	Key    string
+	Reason string
+}
+
+// FormatAWSTags converts tags, such as those returned by GenerateTags, into a
+// list of AWS tags sorted by key. Characters that AWS does not allow are
+// replaced with underscores and keys and values that are too long are
+// truncated; each such change is reported in the returned list of changes.
+//
+// An error is returned if the tags cannot be made valid: a key is empty or uses
+// the reserved "aws:" prefix, two keys are the same once sanitized, or there are
+// more tags than AWS allows on a resource.
+func FormatAWSTags(tags map[string]string) ([]AWSTag, []AWSTagChange, error) {
+	if len(tags) > awsMaxTags {
+		return nil, nil, fmt.Errorf("AWS allows at most %d tags, got %d", awsMaxTags, len(tags))
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		awsTags = make([]AWSTag, 0, len(keys))
+		changes []AWSTagChange
+		seen    = make(map[string]string, len(keys))
+	)
+	for _, key := range keys {
+		if key == "" {
+			return nil, nil, fmt.Errorf("AWS tag keys cannot be empty")
+		}
+		if strings.HasPrefix(strings.ToLower(key), awsReservedKeyPrefix) {
+			return nil, nil, fmt.Errorf("AWS tag key %q uses the reserved prefix %q", key, awsReservedKeyPrefix)
+		}
+
+		awsKey := key
+		if awsInvalidCharacters.MatchString(awsKey) {
+			awsKey = awsInvalidCharacters.ReplaceAllString(awsKey, "_")
+			changes = append(changes, AWSTagChange{key, "replaced invalid characters in key"})
+		}
+		if utf8.RuneCountInString(awsKey) > awsMaxKeyLength {
+			awsKey = truncate(awsKey, awsMaxKeyLength)
+			changes = append(changes, AWSTagChange{key, fmt.Sprintf("truncated key to %d characters", awsMaxKeyLength)})
+		}
+		if original, ok := seen[awsKey]; ok {
+			return nil, nil, fmt.Errorf("AWS tag keys %q and %q are both formatted as %q", original, key, awsKey)
+		}
+		seen[awsKey] = key
+
+		value := tags[key]
+		if awsInvalidCharacters.MatchString(value) {
+			value = awsInvalidCharacters.ReplaceAllString(value, "_")
+			changes = append(changes, AWSTagChange{key, "replaced invalid characters in value"})
+		}
+		if utf8.RuneCountInString(value) > awsMaxValueLength {
+			value = truncate(value, awsMaxValueLength)
+			changes = append(changes, AWSTagChange{key, fmt.Sprintf("truncated value to %d characters", awsMaxValueLength)})
+		}
+
+		awsTags = append(awsTags, AWSTag{Key: awsKey, Value: value})
+	}
+	sort.Slice(awsTags, func(i, j int) bool {
+		return awsTags[i].Key < awsTags[j].Key
+	})
+	return awsTags, changes, nil
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
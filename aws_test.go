@@ -0,0 +1,107 @@
+package brokertags
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatAWSTags(t *testing.T) {
+	tooManyTags := make(map[string]string)
+	for i := 0; i < 51; i++ {
+		tooManyTags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+
+	testCases := map[string]struct {
+		tags            map[string]string
+		expectedTags    []AWSTag
+		expectedChanges []AWSTagChange
+		expectedErr     error
+	}{
+		"valid tags are sorted by key": {
+			tags: map[string]string{
+				"Space name":        "space-1",
+				"client":            "Cloud Foundry",
+				"Organization name": "org-1",
+				"Created at":        "2024-01-02T03:04:05Z",
+			},
+			expectedTags: []AWSTag{
+				{Key: "Created at", Value: "2024-01-02T03:04:05Z"},
+				{Key: "Organization name", Value: "org-1"},
+				{Key: "Space name", Value: "space-1"},
+				{Key: "client", Value: "Cloud Foundry"},
+			},
+		},
+		"invalid characters are replaced": {
+			tags: map[string]string{
+				"Instance name": "my*instance",
+				"cost#center":   "abc",
+			},
+			expectedTags: []AWSTag{
+				{Key: "Instance name", Value: "my_instance"},
+				{Key: "cost_center", Value: "abc"},
+			},
+			expectedChanges: []AWSTagChange{
+				{Key: "Instance name", Reason: "replaced invalid characters in value"},
+				{Key: "cost#center", Reason: "replaced invalid characters in key"},
+			},
+		},
+		"long keys and values are truncated": {
+			tags: map[string]string{
+				strings.Repeat("k", 130): strings.Repeat("v", 300),
+			},
+			expectedTags: []AWSTag{
+				{Key: strings.Repeat("k", 128), Value: strings.Repeat("v", 256)},
+			},
+			expectedChanges: []AWSTagChange{
+				{Key: strings.Repeat("k", 130), Reason: "truncated key to 128 characters"},
+				{Key: strings.Repeat("k", 130), Reason: "truncated value to 256 characters"},
+			},
+		},
+		"reserved prefix": {
+			tags: map[string]string{
+				"AWS:createdBy": "someone",
+			},
+			expectedErr: errors.New(`AWS tag key "AWS:createdBy" uses the reserved prefix "aws:"`),
+		},
+		"empty key": {
+			tags: map[string]string{
+				"": "value",
+			},
+			expectedErr: errors.New("AWS tag keys cannot be empty"),
+		},
+		"keys collide once sanitized": {
+			tags: map[string]string{
+				"cost#center": "abc",
+				"cost_center": "def",
+			},
+			expectedErr: errors.New(`AWS tag keys "cost#center" and "cost_center" are both formatted as "cost_center"`),
+		},
+		"too many tags": {
+			tags:        tooManyTags,
+			expectedErr: errors.New("AWS allows at most 50 tags, got 51"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			awsTags, changes, err := FormatAWSTags(test.tags)
+			if (test.expectedErr != nil && err == nil) ||
+				(err != nil && err.Error() != test.expectedErr.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if test.expectedErr != nil {
+				return
+			}
+			if !cmp.Equal(awsTags, test.expectedTags) {
+				t.Errorf(cmp.Diff(awsTags, test.expectedTags))
+			}
+			if !cmp.Equal(changes, test.expectedChanges) {
+				t.Errorf(cmp.Diff(changes, test.expectedChanges))
+			}
+		})
+	}
+}
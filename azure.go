@@ -0,0 +1,110 @@
+package brokertags
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	azureMaxTags        = 50
+	azureMaxKeyLength   = 512
+	azureMaxValueLength = 256
+)
+
+var (
+	// azureInvalidKeyCharacters matches characters that Azure does not allow in
+	// tag names.
+	azureInvalidKeyCharacters = regexp.MustCompile(`[<>%&\\?/]`)
+
+	// azureReservedKeyPrefixes are prefixes that Azure reserves for its own tags.
+	azureReservedKeyPrefixes = []string{"microsoft", "azure", "windows"}
+)
+
+// AzureDroppedTag is a tag that FormatAzureTags could not include.
+type AzureDroppedTag struct {
+	Key    string
+	Reason string
+}
+
+// AzureTagsDroppedError is returned by FormatAzureTags when one or more tags
+// could not be made valid for Azure and were left out.
+type AzureTagsDroppedError struct {
+	Dropped []AzureDroppedTag
+}
+
+func (e *AzureTagsDroppedError) Error() string {
+	dropped := make([]string, 0, len(e.Dropped))
+	for _, tag := range e.Dropped {
+		dropped = append(dropped, fmt.Sprintf("%q (%s)", tag.Key, tag.Reason))
+	}
+	return "dropped tags not accepted by Azure: " + strings.Join(dropped, ", ")
+}
+
+// FormatAzureTags converts tags, such as those returned by GenerateTags, into
+// tags that Azure accepts. Characters that Azure does not allow in tag names are
+// replaced with underscores, and names and values that are too long are
+// truncated.
+//
+// Tags that still cannot be used are left out of the result: tags with empty or
+// reserved names, tags whose names are the same as another tag's once
+// formatted, ignoring case as Azure does, and any tags over Azure's limit per
+// resource, in key order. If any tags were left out, the remaining tags are
+// returned along with an *AzureTagsDroppedError listing the dropped tags.
+func FormatAzureTags(tags map[string]string) (map[string]string, error) {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		azureTags = make(map[string]string, len(keys))
+		seen      = make(map[string]bool, len(keys))
+		dropped   []AzureDroppedTag
+	)
+	for _, key := range keys {
+		azureKey := azureInvalidKeyCharacters.ReplaceAllString(key, "_")
+		if utf8.RuneCountInString(azureKey) > azureMaxKeyLength {
+			azureKey = truncate(azureKey, azureMaxKeyLength)
+		}
+
+		exists := seen[strings.ToLower(azureKey)]
+
+		var reason string
+		switch {
+		case strings.TrimSpace(azureKey) == "":
+			reason = "empty name"
+		case hasAzureReservedPrefix(azureKey):
+			reason = "reserved name prefix"
+		case exists:
+			reason = fmt.Sprintf("name is the same as another tag once formatted as %q", azureKey)
+		case len(azureTags) >= azureMaxTags:
+			reason = fmt.Sprintf("more than %d tags", azureMaxTags)
+		}
+		if reason != "" {
+			dropped = append(dropped, AzureDroppedTag{Key: key, Reason: reason})
+			continue
+		}
+
+		seen[strings.ToLower(azureKey)] = true
+		azureTags[azureKey] = truncate(tags[key], azureMaxValueLength)
+	}
+
+	if len(dropped) > 0 {
+		return azureTags, &AzureTagsDroppedError{Dropped: dropped}
+	}
+	return azureTags, nil
+}
+
+func hasAzureReservedPrefix(key string) bool {
+	key = strings.ToLower(key)
+	for _, prefix := range azureReservedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
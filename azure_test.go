@@ -0,0 +1,129 @@
+package brokertags
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatAzureTags(t *testing.T) {
+	tooManyTags := make(map[string]string)
+	for i := 10; i < 61; i++ {
+		tooManyTags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	expectedTooManyTags := make(map[string]string)
+	for i := 10; i < 60; i++ {
+		expectedTooManyTags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+
+	testCases := map[string]struct {
+		tags         map[string]string
+		expectedTags map[string]string
+		expectedErr  error
+	}{
+		"valid tags": {
+			tags: map[string]string{
+				"client":            "Cloud Foundry",
+				"Organization name": "org-1",
+			},
+			expectedTags: map[string]string{
+				"client":            "Cloud Foundry",
+				"Organization name": "org-1",
+			},
+		},
+		"invalid characters are replaced": {
+			tags: map[string]string{
+				"cost/center<1>": "a/b",
+			},
+			expectedTags: map[string]string{
+				"cost_center_1_": "a/b",
+			},
+		},
+		"long names and values are truncated": {
+			tags: map[string]string{
+				strings.Repeat("k", 600): strings.Repeat("v", 300),
+			},
+			expectedTags: map[string]string{
+				strings.Repeat("k", 512): strings.Repeat("v", 256),
+			},
+		},
+		"tags are dropped": {
+			tags: map[string]string{
+				"":            "empty",
+				"Azure-owned": "reserved",
+				"cost?center": "abc",
+				"cost_center": "def",
+			},
+			expectedTags: map[string]string{
+				"cost_center": "abc",
+			},
+			expectedErr: &AzureTagsDroppedError{
+				Dropped: []AzureDroppedTag{
+					{Key: "", Reason: "empty name"},
+					{Key: "Azure-owned", Reason: "reserved name prefix"},
+					{Key: "cost_center", Reason: `name is the same as another tag once formatted as "cost_center"`},
+				},
+			},
+		},
+		"names differing only in case": {
+			tags: map[string]string{
+				"Env": "a",
+				"env": "b",
+			},
+			expectedTags: map[string]string{
+				"Env": "a",
+			},
+			expectedErr: &AzureTagsDroppedError{
+				Dropped: []AzureDroppedTag{
+					{Key: "env", Reason: `name is the same as another tag once formatted as "env"`},
+				},
+			},
+		},
+		"too many tags": {
+			tags:         tooManyTags,
+			expectedTags: expectedTooManyTags,
+			expectedErr: &AzureTagsDroppedError{
+				Dropped: []AzureDroppedTag{
+					{Key: "key-60", Reason: "more than 50 tags"},
+				},
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			azureTags, err := FormatAzureTags(test.tags)
+			if test.expectedErr == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.expectedErr != nil {
+				var droppedErr *AzureTagsDroppedError
+				if !errors.As(err, &droppedErr) {
+					t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+				}
+				if !cmp.Equal(droppedErr, test.expectedErr) {
+					t.Errorf(cmp.Diff(droppedErr, test.expectedErr))
+				}
+			}
+			if !cmp.Equal(azureTags, test.expectedTags) {
+				t.Errorf(cmp.Diff(azureTags, test.expectedTags))
+			}
+		})
+	}
+}
+
+func TestAzureTagsDroppedError(t *testing.T) {
+	err := &AzureTagsDroppedError{
+		Dropped: []AzureDroppedTag{
+			{Key: "", Reason: "empty name"},
+			{Key: "Azure-owned", Reason: "reserved name prefix"},
+		},
+	}
+	expected := `dropped tags not accepted by Azure: "" (empty name), "Azure-owned" (reserved name prefix)`
+	if err.Error() != expected {
+		t.Errorf("expected error message: %s, got: %s", expected, err.Error())
+	}
+}
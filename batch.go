@@ -0,0 +1,230 @@
+package brokertags
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// batchQuotaCacheTTL is how long GenerateTagsBatch keeps quotas and isolation
+// segments without WithCache. The cache is dropped with the batch, so this
+// only needs to outlast it.
+const batchQuotaCacheTTL = time.Hour
+
+// BatchRequest describes one service instance to generate tags for with
+// GenerateTagsBatch.
+type BatchRequest struct {
+	ServiceName   string
+	PlanName      string
+	ResourceGUIDs ResourceGUIDs
+}
+
+// BatchResult holds the tags generated for one service instance by
+// GenerateTagsBatch, or the error that generating them failed with.
+type BatchResult struct {
+	Tags map[string]string
+	Err  error
+}
+
+// GenerateTagsBatch generates tags for many service instances, as GenerateTags
+// would for each of them, and returns the results keyed by instance GUID.
+// Every request must have a unique instance GUID.
+//
+// If the ResourceGetter is a ResourceLister, as the CF API one is, the service
+// instances, spaces and organizations are looked up with a few list requests
+// filtered by GUID, rather than with one request for each resource. Errors
+// that only concern one service instance, such as its space not being found,
+// are returned in its BatchResult; the returned error is only non-nil if the
+// requests themselves are invalid.
+func (t *CfTagManager) GenerateTagsBatch(
+	ctx context.Context,
+	action Action,
+	requests []BatchRequest,
+	getMissingResources bool,
+	opts ...GenerateOption,
+) (map[string]BatchResult, error) {
+	if _, err := action.getTagKey(); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(requests))
+	for i, request := range requests {
+		instanceGUID := request.ResourceGUIDs.InstanceGUID
+		if instanceGUID == "" {
+			return nil, fmt.Errorf("batch request %d has no instance GUID", i)
+		}
+		if seen[instanceGUID] {
+			return nil, fmt.Errorf("duplicate instance GUID in batch requests: %s", instanceGUID)
+		}
+		seen[instanceGUID] = true
+	}
+
+	tagManager := t
+	if lister, ok := t.resourceLister(); ok {
+		prefetched := t.prefetch(ctx, lister, requests, getMissingResources)
+		batchTagManager := *t
+		batchTagManager.cfResourceGetter = prefetched
+		batchTagManager.cache = nil
+		if t.quotaGetter != nil && t.cache == nil {
+			// Many of the service instances usually share quotas and isolation
+			// segments, so they are looked up once for the whole batch.
+			batchTagManager.quotaGetter = newCachingResourceGetter(t.uncachedResourceGetter(), batchQuotaCacheTTL, 0)
+		}
+		tagManager = &batchTagManager
+	}
+
+	results := make(map[string]BatchResult, len(requests))
+	for _, request := range requests {
+		tags, err := tagManager.GenerateTagsContext(
+			ctx,
+			action,
+			request.ServiceName,
+			request.PlanName,
+			request.ResourceGUIDs,
+			getMissingResources,
+			opts...,
+		)
+		results[request.ResourceGUIDs.InstanceGUID] = BatchResult{Tags: tags, Err: err}
+	}
+	return results, nil
+}
+
+// resourceLister returns the ResourceGetter of the CfTagManager as a
+// ResourceLister, if it is one. The cache is bypassed, since it only caches
+// lookups of single resources.
+func (t *CfTagManager) resourceLister() (ResourceLister, bool) {
+	lister, ok := t.uncachedResourceGetter().(ResourceLister)
+	return lister, ok
+}
+
+// prefetch lists every service instance, space and organization that
+// generating tags for requests may look up, first the service instances, then
+// their spaces, then the organizations of those.
+func (t *CfTagManager) prefetch(
+	ctx context.Context,
+	lister ResourceLister,
+	requests []BatchRequest,
+	getMissingResources bool,
+) *prefetchedResourceGetter {
+	p := &prefetchedResourceGetter{}
+
+	var instanceGUIDs guidSet
+	for _, request := range requests {
+		instanceGUIDs.add(request.ResourceGUIDs.InstanceGUID)
+	}
+	lookupCtx, cancel := t.lookupContext(ctx)
+	instances, err := lister.ListServiceInstances(lookupCtx, instanceGUIDs.guids)
+	cancel()
+	p.serviceInstances = byGUID(instances, func(instance *resource.ServiceInstance) string { return instance.GUID })
+	p.serviceInstancesErr = err
+
+	requestSpaceGUIDs := make([]string, len(requests))
+	var spaceGUIDs guidSet
+	for i, request := range requests {
+		spaceGUID := request.ResourceGUIDs.SpaceGUID
+		if instance, ok := p.serviceInstances[request.ResourceGUIDs.InstanceGUID]; ok && spaceGUID == "" {
+			spaceGUID = instance.Relationships.Space.Data.GUID
+		}
+		requestSpaceGUIDs[i] = spaceGUID
+		spaceGUIDs.add(spaceGUID)
+	}
+	lookupCtx, cancel = t.lookupContext(ctx)
+	spaces, err := lister.ListSpaces(lookupCtx, spaceGUIDs.guids)
+	cancel()
+	p.spaces = byGUID(spaces, func(space *resource.Space) string { return space.GUID })
+	p.spacesErr = err
+
+	var organizationGUIDs guidSet
+	for i, request := range requests {
+		organizationGUID := request.ResourceGUIDs.OrganizationGUID
+		if organizationGUID == "" && getMissingResources {
+			organizationGUID = t.getOrganizationGuidFromSpace(p.spaces[requestSpaceGUIDs[i]])
+		}
+		organizationGUIDs.add(organizationGUID)
+	}
+	lookupCtx, cancel = t.lookupContext(ctx)
+	organizations, err := lister.ListOrganizations(lookupCtx, organizationGUIDs.guids)
+	cancel()
+	p.organizations = byGUID(organizations, func(organization *resource.Organization) string { return organization.GUID })
+	p.organizationsErr = err
+
+	return p
+}
+
+// guidSet collects GUIDs in the order they are added, without duplicates or
+// empty GUIDs.
+type guidSet struct {
+	guids []string
+	seen  map[string]bool
+}
+
+func (s *guidSet) add(guid string) {
+	if guid == "" || s.seen[guid] {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	s.seen[guid] = true
+	s.guids = append(s.guids, guid)
+}
+
+func byGUID[T any](resources []T, guid func(T) string) map[string]T {
+	m := make(map[string]T, len(resources))
+	for _, r := range resources {
+		m[guid(r)] = r
+	}
+	return m
+}
+
+// prefetchedResourceGetter is a ResourceGetter that serves the resources
+// listed by prefetch. If listing a kind of resource failed, every lookup of
+// that kind fails with the same error.
+type prefetchedResourceGetter struct {
+	organizations       map[string]*resource.Organization
+	organizationsErr    error
+	spaces              map[string]*resource.Space
+	spacesErr           error
+	serviceInstances    map[string]*resource.ServiceInstance
+	serviceInstancesErr error
+}
+
+func (p *prefetchedResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	return prefetched(p.organizations, p.organizationsErr, organizationGUID)
+}
+
+func (p *prefetchedResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	return prefetched(p.spaces, p.spacesErr, spaceGUID)
+}
+
+func (p *prefetchedResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	return prefetched(p.serviceInstances, p.serviceInstancesErr, instanceGUID)
+}
+
+func (p *prefetchedResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	instance, err := p.GetServiceInstance(ctx, instanceGUID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	space := p.spaces[instance.Relationships.Space.Data.GUID]
+	var organization *resource.Organization
+	if space != nil {
+		organization = p.organizations[space.Relationships.Organization.Data.GUID]
+	}
+	return instance, space, organization, nil
+}
+
+func prefetched[T any](resources map[string]*T, err error, guid string) (*T, error) {
+	if err != nil {
+		return nil, err
+	}
+	r, ok := resources[guid]
+	if !ok {
+		return nil, resource.NewResourceNotFoundError()
+	}
+	return r, nil
+}
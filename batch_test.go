@@ -0,0 +1,261 @@
+package brokertags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerateTagsBatch(t *testing.T) {
+	resources := map[string]map[string]any{
+		"/v3/organizations": {
+			"org-guid-1": map[string]any{"guid": "org-guid-1", "name": "org-1"},
+			"org-guid-2": map[string]any{"guid": "org-guid-2", "name": "org-2"},
+		},
+		"/v3/spaces": {
+			"space-guid-1": newSpaceJSON("space-guid-1", "space-1", "org-guid-1"),
+			"space-guid-2": newSpaceJSON("space-guid-2", "space-2", "org-guid-2"),
+		},
+		"/v3/service_instances": {
+			"instance-guid-1": newServiceInstanceJSON("instance-guid-1", "instance-1", "space-guid-1"),
+			"instance-guid-2": newServiceInstanceJSON("instance-guid-2", "instance-2", "space-guid-1"),
+			"instance-guid-3": newServiceInstanceJSON("instance-guid-3", "instance-3", "space-guid-2"),
+		},
+	}
+
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+	)
+	server := newFakeCFAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		listResources(t, w, r, resources[r.URL.Path])
+	})
+
+	tagManager, err := NewCFTagManager("AWS Broker", "testing", server.URL, "client-id", "client-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	results, err := tagManager.GenerateTagsBatch(
+		context.Background(),
+		Update,
+		[]BatchRequest{
+			{ServiceName: "rds", PlanName: "micro", ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+			{ServiceName: "rds", PlanName: "large", ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-2"}},
+			{ServiceName: "s3", PlanName: "basic", ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-3"}},
+			{ServiceName: "s3", PlanName: "basic", ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-4"}},
+		},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedTags := map[string]map[string]string{
+		"instance-guid-1": {
+			BrokerTagKey:              "AWS Broker",
+			ClientTagKey:              "Cloud Foundry",
+			EnvironmentTagKey:         "testing",
+			ServiceNameTagKey:         "rds",
+			ServicePlanName:           "micro",
+			ServiceInstanceGUIDTagKey: "instance-guid-1",
+			ServiceInstanceNameTagKey: "instance-1",
+			SpaceGUIDTagKey:           "space-guid-1",
+			SpaceNameTagKey:           "space-1",
+			OrganizationGUIDTagKey:    "org-guid-1",
+			OrganizationNameTagKey:    "org-1",
+		},
+		"instance-guid-2": {
+			BrokerTagKey:              "AWS Broker",
+			ClientTagKey:              "Cloud Foundry",
+			EnvironmentTagKey:         "testing",
+			ServiceNameTagKey:         "rds",
+			ServicePlanName:           "large",
+			ServiceInstanceGUIDTagKey: "instance-guid-2",
+			ServiceInstanceNameTagKey: "instance-2",
+			SpaceGUIDTagKey:           "space-guid-1",
+			SpaceNameTagKey:           "space-1",
+			OrganizationGUIDTagKey:    "org-guid-1",
+			OrganizationNameTagKey:    "org-1",
+		},
+		"instance-guid-3": {
+			BrokerTagKey:              "AWS Broker",
+			ClientTagKey:              "Cloud Foundry",
+			EnvironmentTagKey:         "testing",
+			ServiceNameTagKey:         "s3",
+			ServicePlanName:           "basic",
+			ServiceInstanceGUIDTagKey: "instance-guid-3",
+			ServiceInstanceNameTagKey: "instance-3",
+			SpaceGUIDTagKey:           "space-guid-2",
+			SpaceNameTagKey:           "space-2",
+			OrganizationGUIDTagKey:    "org-guid-2",
+			OrganizationNameTagKey:    "org-2",
+		},
+	}
+	for instanceGUID, expected := range expectedTags {
+		result := results[instanceGUID]
+		if result.Err != nil {
+			t.Errorf("unexpected error for %s: %s", instanceGUID, result.Err)
+		}
+		delete(result.Tags, "Updated at")
+		if !cmp.Equal(result.Tags, expected) {
+			t.Errorf("unexpected tags for %s: %s", instanceGUID, cmp.Diff(result.Tags, expected))
+		}
+	}
+	if err := results["instance-guid-4"].Err; !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error for instance-guid-4, got: %v", err)
+	}
+
+	expectedRequests := map[string]int{
+		// The fake CF API returns at most two resources per page.
+		"/v3/service_instances": 2,
+		"/v3/spaces":            1,
+		"/v3/organizations":     1,
+	}
+	if !cmp.Equal(requests, expectedRequests) {
+		t.Errorf(cmp.Diff(requests, expectedRequests))
+	}
+}
+
+func TestGenerateTagsBatchInvalidRequests(t *testing.T) {
+	testCases := map[string]struct {
+		action      Action
+		requests    []BatchRequest
+		expectedErr error
+	}{
+		"unknown action": {
+			action:      Action(100),
+			expectedErr: errors.New("unknown action: 100"),
+		},
+		"no instance GUID": {
+			action: Create,
+			requests: []BatchRequest{
+				{ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+				{ResourceGUIDs: ResourceGUIDs{SpaceGUID: "space-guid-1"}},
+			},
+			expectedErr: errors.New("batch request 1 has no instance GUID"),
+		},
+		"duplicate instance GUID": {
+			action: Create,
+			requests: []BatchRequest{
+				{ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+				{ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+			},
+			expectedErr: errors.New("duplicate instance GUID in batch requests: instance-guid-1"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager := &CfTagManager{cfResourceGetter: &mockCFClientWrapper{}}
+			_, err := tagManager.GenerateTagsBatch(context.Background(), test.action, test.requests, false)
+			if err == nil || err.Error() != test.expectedErr.Error() {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerateTagsBatchWithoutLister(t *testing.T) {
+	cfResourceGetter := &mockCFClientWrapper{
+		instanceName: "instance-1",
+		spaceName:    "space-1",
+	}
+	tagManager := &CfTagManager{cfResourceGetter: cfResourceGetter}
+	results, err := tagManager.GenerateTagsBatch(
+		context.Background(),
+		Update,
+		[]BatchRequest{
+			{ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1", SpaceGUID: "space-guid-1"}},
+			{ResourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-2", SpaceGUID: "space-guid-1"}},
+		},
+		false,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, instanceGUID := range []string{"instance-guid-1", "instance-guid-2"} {
+		if results[instanceGUID].Tags[SpaceNameTagKey] != "space-1" {
+			t.Errorf("expected space name for %s: space-1, got: %s", instanceGUID, results[instanceGUID].Tags[SpaceNameTagKey])
+		}
+	}
+	if cfResourceGetter.getServiceInstanceCallCount != 2 {
+		t.Errorf("expected 2 service instance lookups, got: %d", cfResourceGetter.getServiceInstanceCallCount)
+	}
+}
+
+func newSpaceJSON(guid string, name string, organizationGUID string) map[string]any {
+	return map[string]any{
+		"guid": guid,
+		"name": name,
+		"relationships": map[string]any{
+			"organization": map[string]any{"data": map[string]any{"guid": organizationGUID}},
+		},
+	}
+}
+
+func newServiceInstanceJSON(guid string, name string, spaceGUID string) map[string]any {
+	return map[string]any{
+		"guid": guid,
+		"name": name,
+		"relationships": map[string]any{
+			"space": map[string]any{"data": map[string]any{"guid": spaceGUID}},
+		},
+	}
+}
+
+// listResources writes a page of a CF list endpoint response with the
+// resources that match the guids filter of the request. Pages have at most
+// two resources.
+func listResources(t *testing.T, w http.ResponseWriter, r *http.Request, resources map[string]any) {
+	const perPage = 2
+
+	var matches []any
+	for _, guid := range strings.Split(r.URL.Query().Get("guids"), ",") {
+		if resource, ok := resources[guid]; ok {
+			matches = append(matches, resource)
+		}
+	}
+
+	page := 1
+	if r.URL.Query().Has("page") {
+		var err error
+		if page, err = strconv.Atoi(r.URL.Query().Get("page")); err != nil {
+			t.Errorf("invalid page: %s", r.URL.Query().Get("page"))
+		}
+	}
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	var next any
+	if end < len(matches) {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		query.Set("per_page", strconv.Itoa(perPage))
+		next = map[string]string{"href": fmt.Sprintf("http://%s%s?%s", r.Host, r.URL.Path, query.Encode())}
+	}
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"pagination": map[string]any{
+			"total_results": len(matches),
+			"next":          next,
+		},
+		"resources": matches[start:end],
+	})
+	if err != nil {
+		t.Errorf("unexpected error encoding response: %s", err)
+	}
+}
@@ -0,0 +1,330 @@
+// Package brokertagstest provides an in-memory brokertags.ResourceGetter for
+// testing code that generates tags, without a reachable CF API.
+package brokertagstest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+)
+
+var (
+	_ brokertags.ResourceGetter = (*FakeResourceGetter)(nil)
+	_ brokertags.ResourceLister = (*FakeResourceGetter)(nil)
+	_ brokertags.QuotaGetter    = (*FakeResourceGetter)(nil)
+
+	_ brokertags.ServiceInstanceIncludeGetter = (*FakeResourceGetter)(nil)
+)
+
+// FakeResourceGetter is a brokertags.ResourceGetter, brokertags.ResourceLister
+// and brokertags.QuotaGetter that serves organizations, spaces and service
+// instances, and their quotas and isolation segments, from memory. Looking up
+// a resource that was not added returns the same not found error as the CF
+// API.
+//
+// Set the error fields to make lookups and lists of a kind of resource fail,
+// and Delay to make every lookup slow. A delayed lookup returns early with the
+// context's error if the context is done first.
+type FakeResourceGetter struct {
+	GetOrganizationErr     error
+	GetSpaceErr            error
+	GetServiceInstanceErr  error
+	GetQuotaErr            error
+	GetIsolationSegmentErr error
+	Delay                  time.Duration
+
+	mu                          sync.Mutex
+	organizations               map[string]*resource.Organization
+	spaces                      map[string]*resource.Space
+	serviceInstances            map[string]*resource.ServiceInstance
+	organizationQuotas          map[string]*resource.OrganizationQuota
+	spaceQuotas                 map[string]*resource.SpaceQuota
+	isolationSegments           map[string]*resource.IsolationSegment
+	isolationSegmentAssignments map[string]string
+	calls                       map[string]int
+}
+
+// NewFakeResourceGetter returns a FakeResourceGetter without any resources.
+func NewFakeResourceGetter() *FakeResourceGetter {
+	return &FakeResourceGetter{
+		organizations:               make(map[string]*resource.Organization),
+		spaces:                      make(map[string]*resource.Space),
+		serviceInstances:            make(map[string]*resource.ServiceInstance),
+		organizationQuotas:          make(map[string]*resource.OrganizationQuota),
+		spaceQuotas:                 make(map[string]*resource.SpaceQuota),
+		isolationSegments:           make(map[string]*resource.IsolationSegment),
+		isolationSegmentAssignments: make(map[string]string),
+		calls:                       make(map[string]int),
+	}
+}
+
+// AddOrganization adds an organization and returns it, so that tests can set
+// other fields such as its metadata.
+func (f *FakeResourceGetter) AddOrganization(guid string, name string) *resource.Organization {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	organization := &resource.Organization{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+	}
+	f.organizations[guid] = organization
+	return organization
+}
+
+// AddSpace adds a space in the given organization and returns it.
+func (f *FakeResourceGetter) AddSpace(guid string, name string, organizationGUID string) *resource.Space {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	space := &resource.Space{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+		Relationships: &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: organizationGUID,
+				},
+			},
+		},
+	}
+	f.spaces[guid] = space
+	return space
+}
+
+// AddServiceInstance adds a service instance in the given space and returns
+// it.
+func (f *FakeResourceGetter) AddServiceInstance(guid string, name string, spaceGUID string) *resource.ServiceInstance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instance := &resource.ServiceInstance{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: spaceGUID,
+				},
+			},
+		},
+	}
+	f.serviceInstances[guid] = instance
+	return instance
+}
+
+// SetOrganizationQuota adds an organization quota and makes it the quota of
+// the given organization, which must have been added, and returns the quota.
+func (f *FakeResourceGetter) SetOrganizationQuota(organizationGUID string, quotaGUID string, quotaName string) *resource.OrganizationQuota {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	quota := &resource.OrganizationQuota{
+		Name: quotaName,
+		Resource: resource.Resource{
+			GUID: quotaGUID,
+		},
+	}
+	f.organizationQuotas[quotaGUID] = quota
+	if organization, ok := f.organizations[organizationGUID]; ok {
+		organization.Relationships.Quota.Data = &resource.Relationship{GUID: quotaGUID}
+	}
+	return quota
+}
+
+// SetSpaceQuota adds a space quota and makes it the quota of the given space,
+// which must have been added, and returns the quota.
+func (f *FakeResourceGetter) SetSpaceQuota(spaceGUID string, quotaGUID string, quotaName string) *resource.SpaceQuota {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	quota := &resource.SpaceQuota{
+		Name: quotaName,
+		Resource: resource.Resource{
+			GUID: quotaGUID,
+		},
+	}
+	f.spaceQuotas[quotaGUID] = quota
+	if space, ok := f.spaces[spaceGUID]; ok {
+		space.Relationships.Quota = &resource.ToOneRelationship{
+			Data: &resource.Relationship{GUID: quotaGUID},
+		}
+	}
+	return quota
+}
+
+// AddIsolationSegment adds an isolation segment and returns it.
+func (f *FakeResourceGetter) AddIsolationSegment(guid string, name string) *resource.IsolationSegment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	isolationSegment := &resource.IsolationSegment{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+	}
+	f.isolationSegments[guid] = isolationSegment
+	return isolationSegment
+}
+
+// AssignIsolationSegment assigns an isolation segment to the space with the
+// given GUID or, as its default isolation segment, to the organization with
+// the given GUID.
+func (f *FakeResourceGetter) AssignIsolationSegment(guid string, isolationSegmentGUID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.isolationSegmentAssignments[guid] = isolationSegmentGUID
+}
+
+// Calls returns how many times the ResourceGetter or ResourceLister method
+// with the given name, such as "GetSpace", was called.
+func (f *FakeResourceGetter) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *FakeResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	if err := f.call(ctx, "GetOrganization", f.GetOrganizationErr); err != nil {
+		return nil, err
+	}
+	return lookup(f, f.organizations, organizationGUID)
+}
+
+func (f *FakeResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	if err := f.call(ctx, "GetSpace", f.GetSpaceErr); err != nil {
+		return nil, err
+	}
+	return lookup(f, f.spaces, spaceGUID)
+}
+
+func (f *FakeResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	if err := f.call(ctx, "GetServiceInstance", f.GetServiceInstanceErr); err != nil {
+		return nil, err
+	}
+	return lookup(f, f.serviceInstances, instanceGUID)
+}
+
+func (f *FakeResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	if err := f.call(ctx, "GetServiceInstanceIncludeSpaceAndOrganization", f.GetServiceInstanceErr); err != nil {
+		return nil, nil, nil, err
+	}
+	instance, err := lookup(f, f.serviceInstances, instanceGUID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var organization *resource.Organization
+	space := f.spaces[instance.Relationships.Space.Data.GUID]
+	if space != nil {
+		organization = f.organizations[space.Relationships.Organization.Data.GUID]
+	}
+	return instance, space, organization, nil
+}
+
+func (f *FakeResourceGetter) GetOrganizationQuota(ctx context.Context, organizationQuotaGUID string) (*resource.OrganizationQuota, error) {
+	if err := f.call(ctx, "GetOrganizationQuota", f.GetQuotaErr); err != nil {
+		return nil, err
+	}
+	return lookup(f, f.organizationQuotas, organizationQuotaGUID)
+}
+
+func (f *FakeResourceGetter) GetSpaceQuota(ctx context.Context, spaceQuotaGUID string) (*resource.SpaceQuota, error) {
+	if err := f.call(ctx, "GetSpaceQuota", f.GetQuotaErr); err != nil {
+		return nil, err
+	}
+	return lookup(f, f.spaceQuotas, spaceQuotaGUID)
+}
+
+func (f *FakeResourceGetter) GetSpaceIsolationSegment(
+	ctx context.Context,
+	spaceGUID string,
+	organizationGUID string,
+) (*resource.IsolationSegment, error) {
+	if err := f.call(ctx, "GetSpaceIsolationSegment", f.GetIsolationSegmentErr); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	isolationSegmentGUID, ok := f.isolationSegmentAssignments[spaceGUID]
+	if !ok {
+		isolationSegmentGUID, ok = f.isolationSegmentAssignments[organizationGUID]
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return lookup(f, f.isolationSegments, isolationSegmentGUID)
+}
+
+func (f *FakeResourceGetter) ListOrganizations(ctx context.Context, organizationGUIDs []string) ([]*resource.Organization, error) {
+	if err := f.call(ctx, "ListOrganizations", f.GetOrganizationErr); err != nil {
+		return nil, err
+	}
+	return list(f, f.organizations, organizationGUIDs), nil
+}
+
+func (f *FakeResourceGetter) ListSpaces(ctx context.Context, spaceGUIDs []string) ([]*resource.Space, error) {
+	if err := f.call(ctx, "ListSpaces", f.GetSpaceErr); err != nil {
+		return nil, err
+	}
+	return list(f, f.spaces, spaceGUIDs), nil
+}
+
+func (f *FakeResourceGetter) ListServiceInstances(ctx context.Context, instanceGUIDs []string) ([]*resource.ServiceInstance, error) {
+	if err := f.call(ctx, "ListServiceInstances", f.GetServiceInstanceErr); err != nil {
+		return nil, err
+	}
+	return list(f, f.serviceInstances, instanceGUIDs), nil
+}
+
+// call records a call to method, then waits for the configured delay and
+// returns err, if any.
+func (f *FakeResourceGetter) call(ctx context.Context, method string, err error) error {
+	f.mu.Lock()
+	f.calls[method]++
+	f.mu.Unlock()
+
+	if f.Delay > 0 {
+		timer := time.NewTimer(f.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+func lookup[T any](f *FakeResourceGetter, resources map[string]*T, guid string) (*T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := resources[guid]
+	if !ok {
+		return nil, resource.NewResourceNotFoundError()
+	}
+	return r, nil
+}
+
+func list[T any](f *FakeResourceGetter, resources map[string]*T, guids []string) []*T {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []*T
+	for _, guid := range guids {
+		if r, ok := resources[guid]; ok {
+			found = append(found, r)
+		}
+	}
+	return found
+}
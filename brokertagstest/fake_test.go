@@ -0,0 +1,276 @@
+package brokertagstest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/cloud-gov/go-broker-tags/brokertagstest"
+)
+
+func newFake() *brokertagstest.FakeResourceGetter {
+	fake := brokertagstest.NewFakeResourceGetter()
+	fake.AddOrganization("org-guid-1", "org-1")
+	fake.AddSpace("space-guid-1", "space-1", "org-guid-1")
+	fake.AddServiceInstance("instance-guid-1", "instance-1", "space-guid-1")
+	return fake
+}
+
+func TestFakeResourceGetter(t *testing.T) {
+	fake := newFake()
+	tagManager, err := brokertags.NewCFTagManager(
+		"AWS Broker",
+		"testing",
+		"",
+		"",
+		"",
+		brokertags.WithResourceGetter(fake),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tags, err := tagManager.GenerateTags(
+		brokertags.Update,
+		"abc1",
+		"abc2",
+		brokertags.ResourceGUIDs{
+			InstanceGUID: "instance-guid-1",
+		},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	delete(tags, "Updated at")
+
+	expectedTags := map[string]string{
+		"client":                "Cloud Foundry",
+		"broker":                "AWS Broker",
+		"environment":           "testing",
+		"Service offering name": "abc1",
+		"Service plan name":     "abc2",
+		"Organization GUID":     "org-guid-1",
+		"Space GUID":            "space-guid-1",
+		"Instance GUID":         "instance-guid-1",
+		"Instance name":         "instance-1",
+		"Organization name":     "org-1",
+		"Space name":            "space-1",
+	}
+	if !cmp.Equal(tags, expectedTags) {
+		t.Errorf(cmp.Diff(tags, expectedTags))
+	}
+	if fake.Calls("GetServiceInstanceIncludeSpaceAndOrganization") != 1 {
+		t.Errorf("expected 1 call to GetServiceInstanceIncludeSpaceAndOrganization, got %d", fake.Calls("GetServiceInstanceIncludeSpaceAndOrganization"))
+	}
+}
+
+func TestFakeResourceGetterFailures(t *testing.T) {
+	testCases := map[string]struct {
+		configure   func(fake *brokertagstest.FakeResourceGetter)
+		ctx         func() (context.Context, context.CancelFunc)
+		spaceGUID   string
+		expectedErr func(err error) bool
+	}{
+		"not found": {
+			spaceGUID:   "space-guid-2",
+			expectedErr: resource.IsResourceNotFoundError,
+		},
+		"configured error": {
+			configure: func(fake *brokertagstest.FakeResourceGetter) {
+				fake.GetSpaceErr = errors.New("error getting space")
+			},
+			spaceGUID: "space-guid-1",
+			expectedErr: func(err error) bool {
+				return err != nil && err.Error() == "error getting space"
+			},
+		},
+		"slow": {
+			configure: func(fake *brokertagstest.FakeResourceGetter) {
+				fake.Delay = time.Minute
+			},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Millisecond)
+			},
+			spaceGUID: "space-guid-1",
+			expectedErr: func(err error) bool {
+				return errors.Is(err, context.DeadlineExceeded)
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFake()
+			if test.configure != nil {
+				test.configure(fake)
+			}
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if test.ctx != nil {
+				ctx, cancel = test.ctx()
+			}
+			defer cancel()
+
+			_, err := fake.GetSpace(ctx, test.spaceGUID)
+			if !test.expectedErr(err) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestFakeResourceGetterBatch(t *testing.T) {
+	fake := newFake()
+	fake.AddServiceInstance("instance-guid-2", "instance-2", "space-guid-1")
+	tagManager, err := brokertags.NewCFTagManager("AWS Broker", "testing", "", "", "", brokertags.WithResourceGetter(fake))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	results, err := tagManager.GenerateTagsBatch(
+		context.Background(),
+		brokertags.Update,
+		[]brokertags.BatchRequest{
+			{ResourceGUIDs: brokertags.ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+			{ResourceGUIDs: brokertags.ResourceGUIDs{InstanceGUID: "instance-guid-2"}},
+		},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for instanceGUID, expectedName := range map[string]string{
+		"instance-guid-1": "instance-1",
+		"instance-guid-2": "instance-2",
+	} {
+		result := results[instanceGUID]
+		if result.Err != nil {
+			t.Errorf("unexpected error for %s: %s", instanceGUID, result.Err)
+		}
+		if result.Tags["Instance name"] != expectedName || result.Tags["Organization name"] != "org-1" {
+			t.Errorf("unexpected tags for %s: %v", instanceGUID, result.Tags)
+		}
+	}
+
+	for _, method := range []string{"ListServiceInstances", "ListSpaces", "ListOrganizations"} {
+		if calls := fake.Calls(method); calls != 1 {
+			t.Errorf("expected 1 call to %s, got: %d", method, calls)
+		}
+	}
+	if calls := fake.Calls("GetServiceInstance"); calls != 0 {
+		t.Errorf("expected no calls to GetServiceInstance, got: %d", calls)
+	}
+}
+
+func TestFakeResourceGetterQuotas(t *testing.T) {
+	fake := newFake()
+	fake.SetOrganizationQuota("org-guid-1", "org-quota-guid-1", "sandbox")
+	fake.SetSpaceQuota("space-guid-1", "space-quota-guid-1", "small")
+	fake.AddIsolationSegment("isolation-segment-guid-1", "prod")
+	fake.AssignIsolationSegment("org-guid-1", "isolation-segment-guid-1")
+
+	tagManager, err := brokertags.NewCFTagManager(
+		"AWS Broker",
+		"testing",
+		"",
+		"",
+		"",
+		brokertags.WithResourceGetter(fake),
+		brokertags.WithQuotaTags(brokertags.QuotaTagConfig{
+			OrganizationQuota: true,
+			SpaceQuota:        true,
+			IsolationSegment:  true,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tags, err := tagManager.GenerateTags(
+		brokertags.Create,
+		"abc1",
+		"abc2",
+		brokertags.ResourceGUIDs{
+			InstanceGUID: "instance-guid-1",
+		},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedTags := map[string]string{
+		brokertags.OrganizationQuotaTagKey: "sandbox",
+		brokertags.SpaceQuotaTagKey:        "small",
+		brokertags.IsolationSegmentTagKey:  "prod",
+	}
+	for key, expected := range expectedTags {
+		if tags[key] != expected {
+			t.Errorf("expected tag %q to be %q, got %q", key, expected, tags[key])
+		}
+	}
+
+	fake.GetQuotaErr = errors.New("fail")
+	_, err = tagManager.GenerateTags(
+		brokertags.Create,
+		"abc1",
+		"abc2",
+		brokertags.ResourceGUIDs{
+			SpaceGUID: "space-guid-1",
+		},
+		true,
+	)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFakeResourceGetterBatchQuotas(t *testing.T) {
+	fake := newFake()
+	fake.AddServiceInstance("instance-guid-2", "instance-2", "space-guid-1")
+	fake.SetOrganizationQuota("org-guid-1", "org-quota-guid-1", "sandbox")
+	tagManager, err := brokertags.NewCFTagManager(
+		"AWS Broker",
+		"testing",
+		"",
+		"",
+		"",
+		brokertags.WithResourceGetter(fake),
+		brokertags.WithQuotaTags(brokertags.QuotaTagConfig{OrganizationQuota: true, IsolationSegment: true}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	results, err := tagManager.GenerateTagsBatch(
+		context.Background(),
+		brokertags.Update,
+		[]brokertags.BatchRequest{
+			{ResourceGUIDs: brokertags.ResourceGUIDs{InstanceGUID: "instance-guid-1"}},
+			{ResourceGUIDs: brokertags.ResourceGUIDs{InstanceGUID: "instance-guid-2"}},
+		},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for instanceGUID, result := range results {
+		if result.Err != nil {
+			t.Errorf("unexpected error for %s: %s", instanceGUID, result.Err)
+		}
+		if result.Tags[brokertags.OrganizationQuotaTagKey] != "sandbox" {
+			t.Errorf("unexpected tags for %s: %v", instanceGUID, result.Tags)
+		}
+	}
+
+	for _, method := range []string{"GetOrganizationQuota", "GetSpaceIsolationSegment"} {
+		if calls := fake.Calls(method); calls != 1 {
+			t.Errorf("expected 1 call to %s, got: %d", method, calls)
+		}
+	}
+}
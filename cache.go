@@ -0,0 +1,191 @@
+package brokertags
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// CacheStats reports how many lookups were served from the cache and how
+// many had to be passed through to the underlying ResourceGetter.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheKey struct {
+	kind string
+	guid string
+}
+
+type serviceInstanceIncludes struct {
+	instance     *resource.ServiceInstance
+	space        *resource.Space
+	organization *resource.Organization
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   any
+	err     error
+	expires time.Time
+}
+
+// cachingResourceGetter is a ResourceGetter that caches the results of
+// another ResourceGetter for a fixed TTL. Not found errors are cached as
+// well, so repeated lookups of a deleted resource do not reach the CF API.
+// When maxSize is greater than zero, the least recently used entries are
+// evicted once the cache holds more than maxSize entries.
+type cachingResourceGetter struct {
+	next    ResourceGetter
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	stats   CacheStats
+}
+
+func newCachingResourceGetter(next ResourceGetter, ttl time.Duration, maxSize int) *cachingResourceGetter {
+	return &cachingResourceGetter{
+		next:    next,
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *cachingResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	return cachedLookup(c, ctx, "organization", organizationGUID, c.next.GetOrganization)
+}
+
+func (c *cachingResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	return cachedLookup(c, ctx, "space", spaceGUID, c.next.GetSpace)
+}
+
+func (c *cachingResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	return cachedLookup(c, ctx, "service instance", instanceGUID, c.next.GetServiceInstance)
+}
+
+func (c *cachingResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	includes, err := cachedLookup(c, ctx, "service instance with space and organization", instanceGUID,
+		func(ctx context.Context, instanceGUID string) (*serviceInstanceIncludes, error) {
+			next := c.next.(ServiceInstanceIncludeGetter)
+			instance, space, organization, err := next.GetServiceInstanceIncludeSpaceAndOrganization(ctx, instanceGUID)
+			if err != nil {
+				return nil, err
+			}
+			return &serviceInstanceIncludes{instance, space, organization}, nil
+		})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return includes.instance, includes.space, includes.organization, nil
+}
+
+// GetOrganizationQuota, GetSpaceQuota and GetSpaceIsolationSegment make the
+// cache a QuotaGetter. They must only be called if next is one.
+func (c *cachingResourceGetter) GetOrganizationQuota(ctx context.Context, organizationQuotaGUID string) (*resource.OrganizationQuota, error) {
+	return cachedLookup(c, ctx, "organization quota", organizationQuotaGUID, c.next.(QuotaGetter).GetOrganizationQuota)
+}
+
+func (c *cachingResourceGetter) GetSpaceQuota(ctx context.Context, spaceQuotaGUID string) (*resource.SpaceQuota, error) {
+	return cachedLookup(c, ctx, "space quota", spaceQuotaGUID, c.next.(QuotaGetter).GetSpaceQuota)
+}
+
+func (c *cachingResourceGetter) GetSpaceIsolationSegment(
+	ctx context.Context,
+	spaceGUID string,
+	organizationGUID string,
+) (*resource.IsolationSegment, error) {
+	return cachedLookup(c, ctx, "isolation segment", spaceGUID,
+		func(ctx context.Context, spaceGUID string) (*resource.IsolationSegment, error) {
+			return c.next.(QuotaGetter).GetSpaceIsolationSegment(ctx, spaceGUID, organizationGUID)
+		})
+}
+
+func (c *cachingResourceGetter) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func cachedLookup[T any](
+	c *cachingResourceGetter,
+	ctx context.Context,
+	kind string,
+	guid string,
+	lookup func(context.Context, string) (T, error),
+) (T, error) {
+	key := cacheKey{kind, guid}
+	if entry, ok := c.get(key); ok {
+		if entry.err != nil {
+			var zero T
+			return zero, entry.err
+		}
+		return entry.value.(T), nil
+	}
+
+	value, err := lookup(ctx, guid)
+	if err != nil {
+		if classifyError(err) == ErrNotFound {
+			c.set(key, nil, err)
+		}
+		return value, err
+	}
+	c.set(key, value, nil)
+	return value, nil
+}
+
+func (c *cachingResourceGetter) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.stats.Hits++
+			return entry, true
+		}
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+func (c *cachingResourceGetter) set(key cacheKey, value any, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{
+		key:     key,
+		value:   value,
+		err:     err,
+		expires: c.now().Add(c.ttl),
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	if c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
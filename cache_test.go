@@ -0,0 +1,127 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+func TestCachingResourceGetter(t *testing.T) {
+	testCases := map[string]struct {
+		mockResourceGetter *mockCFClientWrapper
+		maxSize            int
+		advance            time.Duration
+		spaceGUIDs         []string
+		expectedCallCount  int
+		expectedStats      CacheStats
+	}{
+		"repeated lookups are cached": {
+			mockResourceGetter: &mockCFClientWrapper{
+				spaceName: "space-1",
+			},
+			spaceGUIDs:        []string{"space-1", "space-1", "space-1"},
+			expectedCallCount: 1,
+			expectedStats:     CacheStats{Hits: 2, Misses: 1},
+		},
+		"expired entries are looked up again": {
+			mockResourceGetter: &mockCFClientWrapper{
+				spaceName: "space-1",
+			},
+			advance:           2 * time.Minute,
+			spaceGUIDs:        []string{"space-1", "space-1"},
+			expectedCallCount: 2,
+			expectedStats:     CacheStats{Hits: 0, Misses: 2},
+		},
+		"not found errors are cached": {
+			mockResourceGetter: &mockCFClientWrapper{
+				getSpaceErr: resource.NewResourceNotFoundError(),
+			},
+			spaceGUIDs:        []string{"space-1", "space-1"},
+			expectedCallCount: 1,
+			expectedStats:     CacheStats{Hits: 1, Misses: 1},
+		},
+		"other errors are not cached": {
+			mockResourceGetter: &mockCFClientWrapper{
+				getSpaceErr: errors.New("error getting space"),
+			},
+			spaceGUIDs:        []string{"space-1", "space-1"},
+			expectedCallCount: 2,
+			expectedStats:     CacheStats{Hits: 0, Misses: 2},
+		},
+		"least recently used entries are evicted": {
+			mockResourceGetter: &mockCFClientWrapper{
+				spaceName: "space-1",
+			},
+			maxSize:           2,
+			spaceGUIDs:        []string{"space-1", "space-2", "space-1", "space-3", "space-2"},
+			expectedCallCount: 4,
+			expectedStats:     CacheStats{Hits: 1, Misses: 4},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			cache := newCachingResourceGetter(test.mockResourceGetter, time.Minute, test.maxSize)
+			cache.now = func() time.Time {
+				return now
+			}
+
+			for _, spaceGUID := range test.spaceGUIDs {
+				cache.GetSpace(context.Background(), spaceGUID)
+				now = now.Add(test.advance)
+			}
+
+			if test.mockResourceGetter.getSpaceInstanceCallCount != test.expectedCallCount {
+				t.Errorf("expected %d calls to getSpace, got %d", test.expectedCallCount, test.mockResourceGetter.getSpaceInstanceCallCount)
+			}
+			if cache.Stats() != test.expectedStats {
+				t.Errorf("expected cache stats: %+v, got: %+v", test.expectedStats, cache.Stats())
+			}
+		})
+	}
+}
+
+func TestGenerateTagsWithCache(t *testing.T) {
+	mockResourceGetter := &mockCFClientWrapper{
+		organizationName: "org-1",
+		spaceName:        "space-1",
+		spaceGUID:        "abc4",
+		organizationGUID: "abc3",
+		instanceGUID:     "abc5",
+		instanceName:     "abc6",
+	}
+	tagManager := newCfTagManager("AWS Broker", "testing", mockResourceGetter, newOptions([]Option{
+		WithCache(time.Minute, 0),
+	}))
+
+	for i := 0; i < 3; i++ {
+		_, err := tagManager.GenerateTags(
+			Update,
+			"abc1",
+			"abc2",
+			ResourceGUIDs{
+				InstanceGUID: "abc5",
+				SpaceGUID:    "abc4",
+			},
+			true,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if mockResourceGetter.getServiceInstanceCallCount != 1 {
+		t.Errorf("expected 1 call to getServiceInstance, got %d", mockResourceGetter.getServiceInstanceCallCount)
+	}
+	if mockResourceGetter.getSpaceInstanceCallCount != 1 {
+		t.Errorf("expected 1 call to getSpace, got %d", mockResourceGetter.getSpaceInstanceCallCount)
+	}
+	expectedStats := CacheStats{Hits: 6, Misses: 3}
+	if tagManager.CacheStats() != expectedStats {
+		t.Errorf("expected cache stats: %+v, got: %+v", expectedStats, tagManager.CacheStats())
+	}
+}
@@ -2,76 +2,398 @@ package brokertags
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
 
 	"github.com/cloudfoundry/go-cfclient/v3/client"
 	"github.com/cloudfoundry/go-cfclient/v3/config"
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 )
 
+// ResourceGetter looks up the CF resources that tags are generated for. The
+// lookups of a CfTagManager created by NewCFTagManager use the CF API, but any
+// implementation can be provided with WithResourceGetter, for instance a fake
+// from the brokertagstest package in tests.
 type ResourceGetter interface {
-	getOrganization(organizationGUID string) (*resource.Organization, error)
-	getSpace(spaceGUID string) (*resource.Space, error)
-	getServiceInstance(instanceGUID string) (*resource.ServiceInstance, error)
+	GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error)
+	GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error)
+	GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error)
+}
+
+// ServiceInstanceIncludeGetter gets a service instance together with its space
+// and organization in one lookup. If the ResourceGetter of a CfTagManager is
+// also a ServiceInstanceIncludeGetter, as the CF API one is, it is used when
+// only the instance GUID is known; otherwise the instance, space and
+// organization are looked up one after the other.
+type ServiceInstanceIncludeGetter interface {
+	GetServiceInstanceIncludeSpaceAndOrganization(
+		ctx context.Context,
+		instanceGUID string,
+	) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error)
+}
+
+// withIncludesOf returns decorator, a ResourceGetter that passes lookups to
+// next, as a ServiceInstanceIncludeGetter only if next is one.
+func withIncludesOf(decorator ResourceGetter, next ResourceGetter) ResourceGetter {
+	if _, ok := next.(ServiceInstanceIncludeGetter); ok {
+		return decorator
+	}
+	return struct{ ResourceGetter }{decorator}
+}
+
+// ResourceLister lists the CF resources with the given GUIDs. If the
+// ResourceGetter of a CfTagManager is also a ResourceLister, GenerateTagsBatch
+// uses it to look up the resources of many service instances at once.
+// Resources that do not exist are left out of the results.
+type ResourceLister interface {
+	ListOrganizations(ctx context.Context, organizationGUIDs []string) ([]*resource.Organization, error)
+	ListSpaces(ctx context.Context, spaceGUIDs []string) ([]*resource.Space, error)
+	ListServiceInstances(ctx context.Context, instanceGUIDs []string) ([]*resource.ServiceInstance, error)
 }
 
 type OrganizationGetter interface {
 	Get(ctx context.Context, guid string) (*resource.Organization, error)
+	ListAll(ctx context.Context, opts *client.OrganizationListOptions) ([]*resource.Organization, error)
 }
 
 type SpaceGetter interface {
 	Get(ctx context.Context, guid string) (*resource.Space, error)
+	ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error)
 }
 
 type ServiceInstanceGetter interface {
 	Get(ctx context.Context, guid string) (*resource.ServiceInstance, error)
+	ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error)
 }
 
+type OrganizationQuotaGetter interface {
+	Get(ctx context.Context, guid string) (*resource.OrganizationQuota, error)
+}
+
+type SpaceQuotaGetter interface {
+	Get(ctx context.Context, guid string) (*resource.SpaceQuota, error)
+}
+
+type IsolationSegmentGetter interface {
+	Get(ctx context.Context, guid string) (*resource.IsolationSegment, error)
+}
+
+// SpaceIsolationSegmentGetter looks up the GUID of the isolation segment
+// assigned to a space, which is empty if there is none.
+type SpaceIsolationSegmentGetter interface {
+	GetAssignedIsolationSegment(ctx context.Context, guid string) (string, error)
+}
+
+// OrganizationIsolationSegmentGetter looks up the GUID of the default isolation
+// segment of an organization, which is empty if there is none.
+type OrganizationIsolationSegmentGetter interface {
+	GetDefaultIsolationSegment(ctx context.Context, guid string) (string, error)
+}
+
+// listGUIDsPerRequest is how many GUIDs are filtered by in each request to a
+// CF list endpoint, which keeps request URLs at a reasonable length.
+const listGUIDsPerRequest = 50
+
+// APIRequester executes raw requests against the CF API, for lookups that
+// go-cfclient does not offer a method for.
+type APIRequester interface {
+	ApiURL(urlPath string) string
+	ExecuteAuthRequest(req *http.Request) (*http.Response, error)
+}
+
+var (
+	_ ResourceGetter = (*cfResourceGetter)(nil)
+	_ ResourceLister = (*cfResourceGetter)(nil)
+
+	_ ServiceInstanceIncludeGetter = (*cfResourceGetter)(nil)
+	_ QuotaGetter                  = (*cfResourceGetter)(nil)
+)
+
 type cfResourceGetter struct {
 	Organizations    OrganizationGetter
 	Spaces           SpaceGetter
 	ServiceInstances ServiceInstanceGetter
+	Requester        APIRequester
+
+	OrganizationQuotas            OrganizationQuotaGetter
+	SpaceQuotas                   SpaceQuotaGetter
+	IsolationSegments             IsolationSegmentGetter
+	SpaceIsolationSegments        SpaceIsolationSegmentGetter
+	OrganizationIsolationSegments OrganizationIsolationSegmentGetter
+
+	retryPolicy RetryPolicy
+	sleep       sleepFunc
+	flights     *flightGroup
+}
+
+type serviceInstanceWithIncluded struct {
+	resource.ServiceInstance
+	Included struct {
+		Spaces        []*resource.Space        `json:"spaces"`
+		Organizations []*resource.Organization `json:"organizations"`
+	} `json:"included"`
 }
 
 func newCFResourceGetter(
 	cfApiUrl string,
 	cfApiClientId string,
 	cfApiClientSecret string,
+	o *options,
 ) (*cfResourceGetter, error) {
-	cfg, err := config.New(cfApiUrl, config.ClientCredentials(cfApiClientId, cfApiClientSecret))
-	if err != nil {
-		return nil, err
-	}
-	cf, err := client.New(cfg)
-	if err != nil {
-		return nil, err
+	cf := o.cfClient
+	if cf == nil {
+		cfg := o.cfConfig
+		if cfg == nil {
+			httpClient, err := newHTTPClient(o)
+			if err != nil {
+				return nil, err
+			}
+			configOptions := append(
+				[]config.Option{
+					config.ClientCredentials(cfApiClientId, cfApiClientSecret),
+					config.HttpClient(httpClient),
+				},
+				o.cfConfigOptions...,
+			)
+			cfg, err = config.New(cfApiUrl, configOptions...)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		cf, err = client.New(cfg)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &cfResourceGetter{
 		Organizations:    cf.Organizations,
 		Spaces:           cf.Spaces,
 		ServiceInstances: cf.ServiceInstances,
+		Requester:        cf,
+
+		OrganizationQuotas:            cf.OrganizationQuotas,
+		SpaceQuotas:                   cf.SpaceQuotas,
+		IsolationSegments:             cf.IsolationSegments,
+		SpaceIsolationSegments:        cf.Spaces,
+		OrganizationIsolationSegments: cf.Organizations,
+
+		retryPolicy: o.retryPolicy,
+		sleep:       sleep,
+		flights:     &flightGroup{},
 	}, nil
 }
 
-func (c *cfResourceGetter) getOrganization(organizationGUID string) (*resource.Organization, error) {
-	organization, err := c.Organizations.Get(context.Background(), organizationGUID)
+// newHTTPClient returns the HTTP client for a CF API client created by
+// NewCFTagManager. It is based on the client set with WithHTTPClient, if any,
+// and its transport records Retry-After headers for retries.
+func newHTTPClient(o *options) (*http.Client, error) {
+	httpClient := &http.Client{}
+	if o.httpClient != nil {
+		client := *o.httpClient
+		httpClient = &client
+	}
+
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if httpTransport, ok := transport.(*http.Transport); ok {
+		// go-cfclient cannot configure TLS for a wrapped transport, so it is
+		// done here instead.
+		httpTransport = httpTransport.Clone()
+		if o.skipTLSValidation {
+			if httpTransport.TLSClientConfig == nil {
+				httpTransport.TLSClientConfig = &tls.Config{}
+			}
+			httpTransport.TLSClientConfig.InsecureSkipVerify = true
+		}
+		transport = httpTransport
+	} else if o.skipTLSValidation {
+		return nil, errors.New("TLS validation can only be skipped for an HTTP client with an *http.Transport")
+	}
+	httpClient.Transport = &retryAfterTransport{next: transport}
+	return httpClient, nil
+}
+
+func (c *cfResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	return getResource(c, ctx, "organization", organizationGUID, c.Organizations.Get)
+}
+
+func (c *cfResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	return getResource(c, ctx, "space", spaceGUID, c.Spaces.Get)
+}
+
+func (c *cfResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	return getResource(c, ctx, "service instance", instanceGUID, c.ServiceInstances.Get)
+}
+
+func (c *cfResourceGetter) GetOrganizationQuota(ctx context.Context, organizationQuotaGUID string) (*resource.OrganizationQuota, error) {
+	return getResource(c, ctx, "organization quota", organizationQuotaGUID, c.OrganizationQuotas.Get)
+}
+
+func (c *cfResourceGetter) GetSpaceQuota(ctx context.Context, spaceQuotaGUID string) (*resource.SpaceQuota, error) {
+	return getResource(c, ctx, "space quota", spaceQuotaGUID, c.SpaceQuotas.Get)
+}
+
+// GetSpaceIsolationSegment looks up the isolation segment assigned to the
+// space, falling back to the default isolation segment of the organization.
+// Looking up an isolation segment takes two requests: one for its GUID, and
+// one for the isolation segment itself.
+func (c *cfResourceGetter) GetSpaceIsolationSegment(
+	ctx context.Context,
+	spaceGUID string,
+	organizationGUID string,
+) (*resource.IsolationSegment, error) {
+	isolationSegmentGUID, err := getResource(
+		c,
+		ctx,
+		"space isolation segment",
+		spaceGUID,
+		c.SpaceIsolationSegments.GetAssignedIsolationSegment,
+	)
 	if err != nil {
 		return nil, err
 	}
-	return organization, nil
+	if isolationSegmentGUID == "" && organizationGUID != "" {
+		isolationSegmentGUID, err = getResource(
+			c,
+			ctx,
+			"organization default isolation segment",
+			organizationGUID,
+			c.OrganizationIsolationSegments.GetDefaultIsolationSegment,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isolationSegmentGUID == "" {
+		return nil, nil
+	}
+	return getResource(c, ctx, "isolation segment", isolationSegmentGUID, c.IsolationSegments.Get)
+}
+
+// getResource gets a single resource with get, merging concurrent lookups of
+// the same resource and retrying transient failures.
+func getResource[T any](
+	c *cfResourceGetter,
+	ctx context.Context,
+	kind string,
+	guid string,
+	get func(context.Context, string) (T, error),
+) (T, error) {
+	return deduplicatedLookup(c.flights, ctx, kind, guid, func(ctx context.Context, guid string) (T, error) {
+		return retry(ctx, c.retryPolicy, c.sleep, func(ctx context.Context) (T, error) {
+			return get(ctx, guid)
+		})
+	})
+}
+
+func (c *cfResourceGetter) ListOrganizations(ctx context.Context, organizationGUIDs []string) ([]*resource.Organization, error) {
+	return listInChunks(organizationGUIDs, func(guids []string) ([]*resource.Organization, error) {
+		return retry(ctx, c.retryPolicy, c.sleep, func(ctx context.Context) ([]*resource.Organization, error) {
+			opts := client.NewOrganizationListOptions()
+			opts.PerPage = listGUIDsPerRequest
+			opts.GUIDs.EqualTo(guids...)
+			return c.Organizations.ListAll(ctx, opts)
+		})
+	})
+}
+
+func (c *cfResourceGetter) ListSpaces(ctx context.Context, spaceGUIDs []string) ([]*resource.Space, error) {
+	return listInChunks(spaceGUIDs, func(guids []string) ([]*resource.Space, error) {
+		return retry(ctx, c.retryPolicy, c.sleep, func(ctx context.Context) ([]*resource.Space, error) {
+			opts := client.NewSpaceListOptions()
+			opts.PerPage = listGUIDsPerRequest
+			opts.GUIDs.EqualTo(guids...)
+			return c.Spaces.ListAll(ctx, opts)
+		})
+	})
+}
+
+func (c *cfResourceGetter) ListServiceInstances(ctx context.Context, instanceGUIDs []string) ([]*resource.ServiceInstance, error) {
+	return listInChunks(instanceGUIDs, func(guids []string) ([]*resource.ServiceInstance, error) {
+		return retry(ctx, c.retryPolicy, c.sleep, func(ctx context.Context) ([]*resource.ServiceInstance, error) {
+			opts := client.NewServiceInstanceListOptions()
+			opts.PerPage = listGUIDsPerRequest
+			opts.GUIDs.EqualTo(guids...)
+			return c.ServiceInstances.ListAll(ctx, opts)
+		})
+	})
+}
+
+// listInChunks lists the resources with the given GUIDs, making one list call
+// for every listGUIDsPerRequest GUIDs.
+func listInChunks[T any](guids []string, list func(guids []string) ([]T, error)) ([]T, error) {
+	var resources []T
+	for start := 0; start < len(guids); start += listGUIDsPerRequest {
+		end := start + listGUIDsPerRequest
+		if end > len(guids) {
+			end = len(guids)
+		}
+		chunk, err := list(guids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, chunk...)
+	}
+	return resources, nil
 }
 
-func (c *cfResourceGetter) getSpace(spaceGUID string) (*resource.Space, error) {
-	space, err := c.Spaces.Get(context.Background(), spaceGUID)
+// GetServiceInstanceIncludeSpaceAndOrganization gets a service instance along
+// with the names and GUIDs of its space and organization in one request, using
+// the fields parameter of the CF v3 API. The returned space and organization
+// are nil if they were not included in the response.
+func (c *cfResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	includes, err := getResource(
+		c,
+		ctx,
+		"service instance with space and organization",
+		instanceGUID,
+		c.getServiceInstanceIncludeSpaceAndOrganization,
+	)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return space, nil
+	return includes.instance, includes.space, includes.organization, nil
 }
 
-func (c *cfResourceGetter) getServiceInstance(instanceGUID string) (*resource.ServiceInstance, error) {
-	instance, err := c.ServiceInstances.Get(context.Background(), instanceGUID)
+func (c *cfResourceGetter) getServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (serviceInstanceIncludes, error) {
+	query := url.Values{}
+	query.Set("fields[space]", "name,guid,relationships.organization")
+	query.Set("fields[space.organization]", "name,guid")
+	resourcePath := "/v3/service_instances/" + url.PathEscape(instanceGUID) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Requester.ApiURL(resourcePath), nil)
 	if err != nil {
-		return nil, err
+		return serviceInstanceIncludes{}, err
+	}
+	resp, err := c.Requester.ExecuteAuthRequest(req)
+	if err != nil {
+		return serviceInstanceIncludes{}, err
+	}
+	defer resp.Body.Close()
+
+	var instance serviceInstanceWithIncluded
+	if err := json.NewDecoder(resp.Body).Decode(&instance); err != nil {
+		return serviceInstanceIncludes{}, err
+	}
+
+	includes := serviceInstanceIncludes{instance: &instance.ServiceInstance}
+	if len(instance.Included.Spaces) > 0 {
+		includes.space = instance.Included.Spaces[0]
+	}
+	if len(instance.Included.Organizations) > 0 {
+		includes.organization = instance.Included.Organizations[0]
 	}
-	return instance, nil
+	return includes, nil
 }
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/config"
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 	"github.com/google/go-cmp/cmp"
 )
@@ -28,6 +32,14 @@ func (o *mockOrganizations) Get(ctx context.Context, guid string) (*resource.Org
 	}, nil
 }
 
+func (o *mockOrganizations) ListAll(ctx context.Context, opts *client.OrganizationListOptions) ([]*resource.Organization, error) {
+	organization, err := o.Get(ctx, o.organizationGuid)
+	if err != nil {
+		return nil, err
+	}
+	return []*resource.Organization{organization}, nil
+}
+
 type mockSpaces struct {
 	getSpaceErr error
 	spaceName   string
@@ -46,6 +58,14 @@ func (s *mockSpaces) Get(ctx context.Context, guid string) (*resource.Space, err
 	}, nil
 }
 
+func (s *mockSpaces) ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error) {
+	space, err := s.Get(ctx, s.spaceGuid)
+	if err != nil {
+		return nil, err
+	}
+	return []*resource.Space{space}, nil
+}
+
 type mockServiceInstances struct {
 	getServiceInstanceErr error
 	instanceName          string
@@ -64,6 +84,14 @@ func (si *mockServiceInstances) Get(ctx context.Context, guid string) (*resource
 	}, nil
 }
 
+func (si *mockServiceInstances) ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error) {
+	instance, err := si.Get(ctx, si.instanceGUID)
+	if err != nil {
+		return nil, err
+	}
+	return []*resource.ServiceInstance{instance}, nil
+}
+
 func TestGetOrganization(t *testing.T) {
 	testCases := map[string]struct {
 		cfResourceGetter     *cfResourceGetter
@@ -95,7 +123,7 @@ func TestGetOrganization(t *testing.T) {
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			organization, err := test.cfResourceGetter.getOrganization(test.organizationGuid)
+			organization, err := test.cfResourceGetter.GetOrganization(context.Background(), test.organizationGuid)
 			if !cmp.Equal(organization, test.expectedOrganization) {
 				t.Errorf(cmp.Diff(organization, test.expectedOrganization))
 			}
@@ -138,7 +166,7 @@ func TestGetSpace(t *testing.T) {
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			space, err := test.cfResourceGetter.getSpace(test.spaceGuid)
+			space, err := test.cfResourceGetter.GetSpace(context.Background(), test.spaceGuid)
 			if !cmp.Equal(space, test.expectedSpace) {
 				t.Errorf(cmp.Diff(space, test.expectedSpace))
 			}
@@ -181,7 +209,7 @@ func TestGetServiceInstance(t *testing.T) {
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			instance, err := test.cfResourceGetter.getServiceInstance(test.instanceGUID)
+			instance, err := test.cfResourceGetter.GetServiceInstance(context.Background(), test.instanceGUID)
 			if !cmp.Equal(instance, test.expectedServiceInstance) {
 				t.Errorf(cmp.Diff(instance, test.expectedServiceInstance))
 			}
@@ -192,3 +220,390 @@ func TestGetServiceInstance(t *testing.T) {
 		})
 	}
 }
+
+type mockRequester struct {
+	url string
+}
+
+func (r *mockRequester) ApiURL(urlPath string) string {
+	return r.url + urlPath
+}
+
+func (r *mockRequester) ExecuteAuthRequest(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func TestGetServiceInstanceIncludeSpaceAndOrganization(t *testing.T) {
+	testCases := map[string]struct {
+		responseBody         string
+		statusCode           int
+		expectedInstanceName string
+		expectedSpace        *resource.Space
+		expectedOrganization *resource.Organization
+		expectedErr          error
+	}{
+		"success": {
+			responseBody: `{
+				"guid": "instance-guid-1",
+				"name": "instance-1",
+				"relationships": {"space": {"data": {"guid": "space-guid-1"}}},
+				"included": {
+					"spaces": [{
+						"guid": "space-guid-1",
+						"name": "space-1",
+						"relationships": {"organization": {"data": {"guid": "org-guid-1"}}}
+					}],
+					"organizations": [{"guid": "org-guid-1", "name": "org-1"}]
+				}
+			}`,
+			statusCode:           http.StatusOK,
+			expectedInstanceName: "instance-1",
+			expectedSpace: &resource.Space{
+				Name: "space-1",
+				Resource: resource.Resource{
+					GUID: "space-guid-1",
+				},
+				Relationships: &resource.SpaceRelationships{
+					Organization: &resource.ToOneRelationship{
+						Data: &resource.Relationship{
+							GUID: "org-guid-1",
+						},
+					},
+				},
+			},
+			expectedOrganization: &resource.Organization{
+				Name: "org-1",
+				Resource: resource.Resource{
+					GUID: "org-guid-1",
+				},
+			},
+		},
+		"nothing included": {
+			responseBody:         `{"guid": "instance-guid-1", "name": "instance-1"}`,
+			statusCode:           http.StatusOK,
+			expectedInstanceName: "instance-1",
+		},
+		"error": {
+			statusCode:  http.StatusNotFound,
+			expectedErr: errors.New("unexpected status code: 404"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/service_instances/instance-guid-1" {
+					t.Errorf("unexpected path: %s", r.URL.Path)
+				}
+				if r.URL.Query().Get("fields[space]") != "name,guid,relationships.organization" {
+					t.Errorf("unexpected fields[space]: %s", r.URL.Query().Get("fields[space]"))
+				}
+				if r.URL.Query().Get("fields[space.organization]") != "name,guid" {
+					t.Errorf("unexpected fields[space.organization]: %s", r.URL.Query().Get("fields[space.organization]"))
+				}
+				w.WriteHeader(test.statusCode)
+				w.Write([]byte(test.responseBody))
+			}))
+			defer server.Close()
+
+			cfResourceGetter := &cfResourceGetter{
+				Requester: &mockRequester{url: server.URL},
+			}
+			instance, space, organization, err := cfResourceGetter.GetServiceInstanceIncludeSpaceAndOrganization(context.Background(), "instance-guid-1")
+			if (test.expectedErr != nil && err == nil) ||
+				(err != nil && err.Error() != test.expectedErr.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if instance != nil && instance.Name != test.expectedInstanceName {
+				t.Errorf("expected instance name: %s, got: %s", test.expectedInstanceName, instance.Name)
+			}
+			if !cmp.Equal(space, test.expectedSpace) {
+				t.Errorf(cmp.Diff(space, test.expectedSpace))
+			}
+			if !cmp.Equal(organization, test.expectedOrganization) {
+				t.Errorf(cmp.Diff(organization, test.expectedOrganization))
+			}
+		})
+	}
+}
+
+// newFakeCFAPI starts a server that stands in for the CF API and UAA. It
+// answers API root discovery and token requests itself and passes any other
+// request to handler.
+func newFakeCFAPI(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := newUnstartedFakeCFAPI(t, handler)
+	server.Start()
+	return server
+}
+
+// newFakeTLSCFAPI is like newFakeCFAPI, but serves HTTPS with a certificate
+// that is not trusted by default.
+func newFakeTLSCFAPI(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := newUnstartedFakeCFAPI(t, handler)
+	server.StartTLS()
+	return server
+}
+
+func newUnstartedFakeCFAPI(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `{"links": {"login": {"href": "%[1]s"}, "uaa": {"href": "%[1]s"}}}`, server.URL)
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`)
+		default:
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewCFTagManager(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/spaces/space-guid-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("User-Agent") != "test-agent" {
+			t.Errorf("unexpected User-Agent: %s", r.Header.Get("User-Agent"))
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected Authorization: %s", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"guid": "space-guid-1", "name": "space-1"}`)
+	}
+	server := newFakeCFAPI(t, handler)
+	tlsServer := newFakeTLSCFAPI(t, handler)
+
+	newConfig := func() *config.Config {
+		cfg, err := config.New(
+			server.URL,
+			config.ClientCredentials("client-id", "client-secret"),
+			config.UserAgent("test-agent"),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error creating config: %s", err)
+		}
+		return cfg
+	}
+	newClient := func() *client.Client {
+		cf, err := client.New(newConfig())
+		if err != nil {
+			t.Fatalf("unexpected error creating client: %s", err)
+		}
+		return cf
+	}
+
+	testCases := map[string]struct {
+		newTagManager func() (*CfTagManager, error)
+		expectedErr   string
+	}{
+		"client credentials": {
+			newTagManager: func() (*CfTagManager, error) {
+				return NewCFTagManager(
+					"AWS Broker",
+					"testing",
+					server.URL,
+					"client-id",
+					"client-secret",
+					WithHTTPClient(&http.Client{}),
+					WithUserAgent("test-agent"),
+				)
+			},
+		},
+		"skip TLS validation": {
+			newTagManager: func() (*CfTagManager, error) {
+				return NewCFTagManager(
+					"AWS Broker",
+					"testing",
+					tlsServer.URL,
+					"client-id",
+					"client-secret",
+					WithSkipTLSValidation(),
+					WithUserAgent("test-agent"),
+				)
+			},
+		},
+		"skip TLS validation with a wrapped transport": {
+			newTagManager: func() (*CfTagManager, error) {
+				return NewCFTagManager(
+					"AWS Broker",
+					"testing",
+					tlsServer.URL,
+					"client-id",
+					"client-secret",
+					WithHTTPClient(&http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}),
+					WithSkipTLSValidation(),
+				)
+			},
+			expectedErr: "TLS validation can only be skipped for an HTTP client with an *http.Transport",
+		},
+		"existing config": {
+			newTagManager: func() (*CfTagManager, error) {
+				return NewCFTagManager("AWS Broker", "testing", "", "", "", WithConfig(newConfig()))
+			},
+		},
+		"existing client": {
+			newTagManager: func() (*CfTagManager, error) {
+				return NewCFTagManager("AWS Broker", "testing", "", "", "", WithCFClient(newClient()))
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager, err := test.newTagManager()
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			tags, err := tagManager.GenerateTags(Create, "abc1", "abc2", ResourceGUIDs{SpaceGUID: "space-guid-1"}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tags[SpaceNameTagKey] != "space-1" {
+				t.Errorf("expected space name: space-1, got: %s", tags[SpaceNameTagKey])
+			}
+		})
+	}
+}
+
+func TestListInChunks(t *testing.T) {
+	guids := make([]string, 120)
+	for i := range guids {
+		guids[i] = fmt.Sprintf("guid-%d", i)
+	}
+
+	var chunkSizes []int
+	listed, err := listInChunks(guids, func(chunk []string) ([]string, error) {
+		chunkSizes = append(chunkSizes, len(chunk))
+		return chunk, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cmp.Equal(listed, guids) {
+		t.Errorf(cmp.Diff(listed, guids))
+	}
+	if expected := []int{50, 50, 20}; !cmp.Equal(chunkSizes, expected) {
+		t.Errorf(cmp.Diff(chunkSizes, expected))
+	}
+}
+
+type mockIsolationSegments struct {
+	spaceIsolationSegments        map[string]string
+	organizationIsolationSegments map[string]string
+	names                         map[string]string
+	err                           error
+}
+
+func (m *mockIsolationSegments) GetAssignedIsolationSegment(ctx context.Context, guid string) (string, error) {
+	return m.spaceIsolationSegments[guid], m.err
+}
+
+func (m *mockIsolationSegments) GetDefaultIsolationSegment(ctx context.Context, guid string) (string, error) {
+	return m.organizationIsolationSegments[guid], nil
+}
+
+func (m *mockIsolationSegments) Get(ctx context.Context, guid string) (*resource.IsolationSegment, error) {
+	name, ok := m.names[guid]
+	if !ok {
+		return nil, resource.NewResourceNotFoundError()
+	}
+	return &resource.IsolationSegment{Name: name}, nil
+}
+
+func TestGetSpaceIsolationSegment(t *testing.T) {
+	isolationSegments := &mockIsolationSegments{
+		spaceIsolationSegments:        map[string]string{"space-guid-1": "segment-guid-1"},
+		organizationIsolationSegments: map[string]string{"org-guid-1": "segment-guid-2"},
+		names: map[string]string{
+			"segment-guid-1": "space-segment",
+			"segment-guid-2": "org-segment",
+		},
+	}
+
+	testCases := map[string]struct {
+		isolationSegments *mockIsolationSegments
+		spaceGUID         string
+		organizationGUID  string
+		expectedName      string
+		expectedErr       error
+	}{
+		"assigned to space": {
+			isolationSegments: isolationSegments,
+			spaceGUID:         "space-guid-1",
+			organizationGUID:  "org-guid-1",
+			expectedName:      "space-segment",
+		},
+		"organization default": {
+			isolationSegments: isolationSegments,
+			spaceGUID:         "space-guid-2",
+			organizationGUID:  "org-guid-1",
+			expectedName:      "org-segment",
+		},
+		"none": {
+			isolationSegments: isolationSegments,
+			spaceGUID:         "space-guid-2",
+			organizationGUID:  "org-guid-2",
+		},
+		"no organization GUID": {
+			isolationSegments: isolationSegments,
+			spaceGUID:         "space-guid-2",
+		},
+		"error": {
+			isolationSegments: &mockIsolationSegments{err: errors.New("fail")},
+			spaceGUID:         "space-guid-1",
+			expectedErr:       errors.New("fail"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := &cfResourceGetter{
+				IsolationSegments:             test.isolationSegments,
+				SpaceIsolationSegments:        test.isolationSegments,
+				OrganizationIsolationSegments: test.isolationSegments,
+			}
+			isolationSegment, err := c.GetSpaceIsolationSegment(context.Background(), test.spaceGUID, test.organizationGUID)
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var name string
+			if isolationSegment != nil {
+				name = isolationSegment.Name
+			}
+			if name != test.expectedName {
+				t.Errorf("expected isolation segment: %q, got: %q", test.expectedName, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package brokertags
+
+import "time"
+
+// Clock tells the current time. Setting a Clock with WithClock lets callers,
+// such as tests, control the timestamps in generated tags.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function that implements Clock.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
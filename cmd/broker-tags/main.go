@@ -0,0 +1,234 @@
+// Command broker-tags generates the tags that the cloud.gov service brokers
+// would give the resources of a service instance, space or organization, using
+// the same logic as the brokers, to help debug cost allocation.
+//
+// CF API credentials are read from the CF_API_URL, CF_API_CLIENT_ID and
+// CF_API_CLIENT_SECRET environment variables, or from the corresponding
+// flags. With -fixture, names are read from a YAML or JSON resource fixture
+// instead, and the CF API is not used. Run broker-tags -h for the full list
+// of flags.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+)
+
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+	outputAWS   = "aws"
+)
+
+type config struct {
+	apiURL            string
+	clientID          string
+	clientSecret      string
+	skipTLSValidation bool
+	fixturePath       string
+
+	broker              string
+	environment         string
+	serviceName         string
+	planName            string
+	action              brokertags.Action
+	resourceGUIDs       brokertags.ResourceGUIDs
+	getMissingResources bool
+	bestEffort          bool
+	timeout             time.Duration
+	output              string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+func run(args []string, getenv func(string) string, stdout io.Writer, stderr io.Writer) int {
+	cfg, err := parseFlags(args, getenv, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "broker-tags: %s\n", err)
+		return 2
+	}
+
+	tagManager, err := newTagManager(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "broker-tags: %s\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	var generateOptions []brokertags.GenerateOption
+	if cfg.bestEffort {
+		generateOptions = append(generateOptions, brokertags.WithBestEffort())
+	}
+	tags, err := tagManager.GenerateTagsContext(
+		ctx,
+		cfg.action,
+		cfg.serviceName,
+		cfg.planName,
+		cfg.resourceGUIDs,
+		cfg.getMissingResources,
+		generateOptions...,
+	)
+	var partialErr *brokertags.PartialTagsError
+	if errors.As(err, &partialErr) {
+		for _, warning := range partialErr.Warnings {
+			fmt.Fprintf(stderr, "broker-tags: warning: %s\n", warning)
+		}
+	} else if err != nil {
+		fmt.Fprintf(stderr, "broker-tags: error generating tags: %s\n", err)
+		return 1
+	}
+
+	if err := writeTags(stdout, stderr, tags, cfg.output); err != nil {
+		fmt.Fprintf(stderr, "broker-tags: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func parseFlags(args []string, getenv func(string) string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	var action string
+
+	flags := flag.NewFlagSet("broker-tags", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprintln(output, "Usage: broker-tags [flags] -instance GUID | -space GUID | -org GUID")
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "Generates the tags that a service broker would give to a resource.")
+		fmt.Fprintln(output)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cfg.apiURL, "api", getenv("CF_API_URL"), "CF API `URL` (default $CF_API_URL)")
+	flags.StringVar(&cfg.clientID, "client-id", getenv("CF_API_CLIENT_ID"), "CF API client `ID` (default $CF_API_CLIENT_ID)")
+	flags.StringVar(&cfg.clientSecret, "client-secret", getenv("CF_API_CLIENT_SECRET"), "CF API client `secret` (default $CF_API_CLIENT_SECRET)")
+	flags.BoolVar(&cfg.skipTLSValidation, "skip-ssl-validation", false, "skip TLS certificate validation of the CF API")
+	flags.StringVar(&cfg.fixturePath, "fixture", "", "read names from a YAML or JSON resource fixture `file` instead of the CF API")
+	flags.StringVar(&cfg.broker, "broker", "", "broker `name` for the broker tag")
+	flags.StringVar(&cfg.environment, "environment", "", "`name` for the environment tag")
+	flags.StringVar(&cfg.serviceName, "service", "", "service offering `name`")
+	flags.StringVar(&cfg.planName, "plan", "", "service plan `name`")
+	flags.StringVar(&action, "action", brokertags.Create.String(), "`action` to generate tags for: create, update, bind, unbind, delete or restore")
+	flags.StringVar(&cfg.resourceGUIDs.InstanceGUID, "instance", "", "service instance `GUID`")
+	flags.StringVar(&cfg.resourceGUIDs.SpaceGUID, "space", "", "space `GUID`")
+	flags.StringVar(&cfg.resourceGUIDs.OrganizationGUID, "org", "", "organization `GUID`")
+	flags.BoolVar(&cfg.getMissingResources, "get-missing", true, "look up the organization of the space")
+	flags.BoolVar(&cfg.bestEffort, "best-effort", false, "output the tags that could be generated even if lookups fail")
+	flags.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "how long to wait for the CF API")
+	flags.StringVar(&cfg.output, "output", outputTable, "output `format`: json, yaml, table or aws")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	var err error
+	if cfg.action, err = brokertags.ParseAction(action); err != nil {
+		return nil, err
+	}
+	if cfg.resourceGUIDs == (brokertags.ResourceGUIDs{}) {
+		return nil, errors.New("one of -instance, -space or -org is required")
+	}
+	if cfg.fixturePath == "" && (cfg.apiURL == "" || cfg.clientID == "" || cfg.clientSecret == "") {
+		return nil, errors.New("CF API URL, client ID and client secret are required")
+	}
+	switch cfg.output {
+	case outputJSON, outputYAML, outputTable, outputAWS:
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", cfg.output)
+	}
+	return cfg, nil
+}
+
+func newTagManager(cfg *config) (*brokertags.CfTagManager, error) {
+	if cfg.fixturePath != "" {
+		fixture, err := brokertags.LoadResourceFixture(cfg.fixturePath)
+		if err != nil {
+			return nil, err
+		}
+		return brokertags.NewOfflineTagManager(cfg.broker, cfg.environment, fixture)
+	}
+	tagManager, err := brokertags.NewCFTagManager(
+		cfg.broker,
+		cfg.environment,
+		cfg.apiURL,
+		cfg.clientID,
+		cfg.clientSecret,
+		newTagManagerOptions(cfg)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CF API client: %w", err)
+	}
+	return tagManager, nil
+}
+
+func newTagManagerOptions(cfg *config) []brokertags.Option {
+	var opts []brokertags.Option
+	if cfg.skipTLSValidation {
+		opts = append(opts, brokertags.WithSkipTLSValidation())
+	}
+	return append(opts, brokertags.WithUserAgent("broker-tags"))
+}
+
+// writeTags writes tags to w in the given output format. Adjustments made to
+// fit the tags to AWS's rules are reported to stderr.
+func writeTags(w io.Writer, stderr io.Writer, tags map[string]string, output string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tags)
+	case outputYAML:
+		return yaml.NewEncoder(w).Encode(tags)
+	case outputTable:
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "KEY\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(table, "%s\t%s\n", key, tags[key])
+		}
+		return table.Flush()
+	case outputAWS:
+		awsTags, changes, err := brokertags.FormatAWSTags(tags)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			fmt.Fprintf(stderr, "broker-tags: warning: tag %q: %s\n", change.Key, change.Reason)
+		}
+		// FormatAWSTags replaces quotes and commas, so only quoting for the
+		// shell is needed.
+		args := make([]string, 0, len(awsTags))
+		for _, tag := range awsTags {
+			args = append(args, fmt.Sprintf("'Key=%s,Value=%s'", tag.Key, tag.Value))
+		}
+		_, err = fmt.Fprintf(w, "--tags %s\n", strings.Join(args, " "))
+		return err
+	}
+	return fmt.Errorf("unknown output format: %s", output)
+}
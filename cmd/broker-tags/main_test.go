@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+)
+
+func TestParseFlags(t *testing.T) {
+	env := map[string]string{
+		"CF_API_URL":           "https://api.example.com",
+		"CF_API_CLIENT_ID":     "client-id",
+		"CF_API_CLIENT_SECRET": "client-secret",
+	}
+
+	testCases := map[string]struct {
+		args           []string
+		env            map[string]string
+		expectedConfig *config
+		expectedErr    string
+	}{
+		"credentials from env": {
+			args: []string{"-instance", "instance-guid-1", "-action", "update", "-output", "json"},
+			env:  env,
+			expectedConfig: &config{
+				apiURL:              "https://api.example.com",
+				clientID:            "client-id",
+				clientSecret:        "client-secret",
+				action:              brokertags.Update,
+				resourceGUIDs:       brokertags.ResourceGUIDs{InstanceGUID: "instance-guid-1"},
+				getMissingResources: true,
+				timeout:             30 * time.Second,
+				output:              outputJSON,
+			},
+		},
+		"credentials from flags": {
+			args: []string{
+				"-api", "https://api.example.org",
+				"-client-id", "other-id",
+				"-client-secret", "other-secret",
+				"-space", "space-guid-1",
+				"-get-missing=false",
+			},
+			env: env,
+			expectedConfig: &config{
+				apiURL:        "https://api.example.org",
+				clientID:      "other-id",
+				clientSecret:  "other-secret",
+				action:        brokertags.Create,
+				resourceGUIDs: brokertags.ResourceGUIDs{SpaceGUID: "space-guid-1"},
+				timeout:       30 * time.Second,
+				output:        outputTable,
+			},
+		},
+		"missing credentials": {
+			args:        []string{"-org", "org-guid-1"},
+			expectedErr: "CF API URL, client ID and client secret are required",
+		},
+		"fixture without credentials": {
+			args: []string{"-fixture", "fixture.yml", "-org", "org-guid-1"},
+			expectedConfig: &config{
+				fixturePath:         "fixture.yml",
+				action:              brokertags.Create,
+				resourceGUIDs:       brokertags.ResourceGUIDs{OrganizationGUID: "org-guid-1"},
+				getMissingResources: true,
+				timeout:             30 * time.Second,
+				output:              outputTable,
+			},
+		},
+		"missing GUID": {
+			env:         env,
+			expectedErr: "one of -instance, -space or -org is required",
+		},
+		"unknown action": {
+			args:        []string{"-org", "org-guid-1", "-action", "archive"},
+			env:         env,
+			expectedErr: `unknown action: "archive"`,
+		},
+		"unknown output format": {
+			args:        []string{"-org", "org-guid-1", "-output", "xml"},
+			env:         env,
+			expectedErr: "unknown output format: xml",
+		},
+		"unexpected arguments": {
+			args:        []string{"-org", "org-guid-1", "extra"},
+			env:         env,
+			expectedErr: "unexpected arguments: extra",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			getenv := func(key string) string { return test.env[key] }
+			cfg, err := parseFlags(test.args, getenv, &bytes.Buffer{})
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cmp.Equal(cfg, test.expectedConfig, cmp.AllowUnexported(config{})) {
+				t.Errorf(cmp.Diff(cfg, test.expectedConfig, cmp.AllowUnexported(config{})))
+			}
+		})
+	}
+}
+
+func TestWriteTags(t *testing.T) {
+	tags := map[string]string{
+		"broker":     "AWS Broker",
+		"Space name": "space-1",
+	}
+
+	testCases := map[string]struct {
+		output         string
+		expectedOutput string
+	}{
+		"json": {
+			output:         outputJSON,
+			expectedOutput: "{\n  \"Space name\": \"space-1\",\n  \"broker\": \"AWS Broker\"\n}\n",
+		},
+		"yaml": {
+			output:         outputYAML,
+			expectedOutput: "Space name: space-1\nbroker: AWS Broker\n",
+		},
+		"table": {
+			output:         outputTable,
+			expectedOutput: "KEY         VALUE\nSpace name  space-1\nbroker      AWS Broker\n",
+		},
+		"aws": {
+			output:         outputAWS,
+			expectedOutput: "--tags 'Key=Space name,Value=space-1' 'Key=broker,Value=AWS Broker'\n",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := writeTags(&stdout, &stderr, tags, test.output); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if stdout.String() != test.expectedOutput {
+				t.Errorf(cmp.Diff(stdout.String(), test.expectedOutput))
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `{"links": {"login": {"href": "%[1]s"}, "uaa": {"href": "%[1]s"}}}`, server.URL)
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`)
+		case "/v3/spaces/space-guid-1":
+			fmt.Fprint(w, `{"guid": "space-guid-1", "name": "space-1", "relationships": {"organization": {"data": {"guid": "org-guid-1"}}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	getenv := func(key string) string {
+		return map[string]string{
+			"CF_API_URL":           server.URL,
+			"CF_API_CLIENT_ID":     "client-id",
+			"CF_API_CLIENT_SECRET": "client-secret",
+		}[key]
+	}
+
+	t.Run("success", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-space", "space-guid-1", "-get-missing=false", "-broker", "AWS Broker"}, getenv, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got: %d: %s", code, stderr.String())
+		}
+		for _, expected := range []string{"Space name  space-1", "broker      AWS Broker"} {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Errorf("expected output to contain %q, got:\n%s", expected, stdout.String())
+			}
+		}
+	})
+
+	t.Run("lookup fails", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-space", "space-guid-1"}, getenv, &stdout, &stderr)
+		if code != 1 {
+			t.Fatalf("expected exit code 1, got: %d", code)
+		}
+		if !strings.Contains(stderr.String(), "error getting organization org-guid-1") {
+			t.Errorf("unexpected error output: %s", stderr.String())
+		}
+	})
+
+	t.Run("fixture", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fixture.json")
+		fixture := `{"spaces": [{"guid": "space-guid-2", "name": "space-2", "organization_guid": "org-guid-2"}]}`
+		if err := os.WriteFile(path, []byte(fixture), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-fixture", path, "-space", "space-guid-2", "-output", "json"}, getenv, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got: %d: %s", code, stderr.String())
+		}
+		for _, expected := range []string{`"Space name": "space-2"`, `"Organization name": "org-guid-2"`} {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Errorf("expected output to contain %q, got:\n%s", expected, stdout.String())
+			}
+		}
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-space", "space-guid-1", "-best-effort", "-output", "json"}, getenv, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got: %d: %s", code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), "warning: error getting organization org-guid-1") {
+			t.Errorf("expected a warning about the organization, got: %s", stderr.String())
+		}
+		if !strings.Contains(stdout.String(), `"Organization GUID": "org-guid-1"`) {
+			t.Errorf("expected the organization GUID in the output, got:\n%s", stdout.String())
+		}
+	})
+}
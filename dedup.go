@@ -0,0 +1,94 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DedupStats reports how many CF API lookups were made, and how many more
+// were avoided because an identical lookup was already in flight and its
+// result was shared instead.
+type DedupStats struct {
+	Lookups      uint64
+	Deduplicated uint64
+}
+
+type flight struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// flightGroup merges concurrent lookups of the same resource, so that only
+// the first one reaches the CF API and the others wait for its result.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[cacheKey]*flight
+	stats   DedupStats
+}
+
+func (g *flightGroup) Stats() DedupStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// deduplicatedLookup calls lookup, unless a lookup with the same kind and
+// GUID is already in flight, in which case it waits for that lookup's result.
+// If the lookup in flight is canceled by its own caller, the waiting callers
+// whose contexts are not done look up the resource again. A nil flightGroup
+// does not deduplicate anything.
+func deduplicatedLookup[T any](
+	g *flightGroup,
+	ctx context.Context,
+	kind string,
+	guid string,
+	lookup func(context.Context, string) (T, error),
+) (T, error) {
+	if g == nil {
+		return lookup(ctx, guid)
+	}
+
+	key := cacheKey{kind, guid}
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.stats.Deduplicated++
+		g.mu.Unlock()
+
+		var zero T
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-f.done:
+		}
+		if isContextError(f.err) && ctx.Err() == nil {
+			return deduplicatedLookup(g, ctx, kind, guid, lookup)
+		}
+		if f.err != nil {
+			return zero, f.err
+		}
+		return f.value.(T), nil
+	}
+	f := &flight{done: make(chan struct{})}
+	if g.flights == nil {
+		g.flights = make(map[cacheKey]*flight)
+	}
+	g.flights[key] = f
+	g.stats.Lookups++
+	g.mu.Unlock()
+
+	value, err := lookup(ctx, guid)
+	f.value, f.err = value, err
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+
+	return value, err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
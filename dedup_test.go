@@ -0,0 +1,168 @@
+package brokertags
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForDeduplicated waits until g has deduplicated n lookups.
+func waitForDeduplicated(t *testing.T, g *flightGroup, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for g.Stats().Deduplicated < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d deduplicated lookups, got: %d", n, g.Stats().Deduplicated)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeduplicatedLookup(t *testing.T) {
+	const callers = 5
+	var (
+		g       = &flightGroup{}
+		calls   atomic.Int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		results = make([]string, callers)
+		errs    = make([]error, callers)
+	)
+	lookup := func(ctx context.Context, guid string) (string, error) {
+		calls.Add(1)
+		<-release
+		return "name of " + guid, nil
+	}
+
+	wg.Add(callers)
+	go func() {
+		defer wg.Done()
+		results[0], errs[0] = deduplicatedLookup(g, context.Background(), "space", "space-guid-1", lookup)
+	}()
+	for g.Stats().Lookups == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = deduplicatedLookup(g, context.Background(), "space", "space-guid-1", lookup)
+		}(i)
+	}
+	waitForDeduplicated(t, g, callers-1)
+	close(release)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil || results[i] != "name of space-guid-1" {
+			t.Errorf("unexpected result of caller %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 lookup, got: %d", calls.Load())
+	}
+	if stats := g.Stats(); stats != (DedupStats{Lookups: 1, Deduplicated: callers - 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Lookups that are no longer in flight are not deduplicated.
+	if _, err := deduplicatedLookup(g, context.Background(), "space", "space-guid-1", lookup); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 lookups, got: %d", calls.Load())
+	}
+}
+
+func TestDeduplicatedLookupCanceled(t *testing.T) {
+	var (
+		g        = &flightGroup{}
+		started  = make(chan struct{})
+		calls    atomic.Int32
+		leaderWG sync.WaitGroup
+	)
+	lookup := func(ctx context.Context, guid string) (string, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "space-1", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderWG.Add(1)
+	go func() {
+		defer leaderWG.Done()
+		if _, err := deduplicatedLookup(g, ctx, "space", "space-guid-1", lookup); err != context.Canceled {
+			t.Errorf("expected the canceled caller to get context.Canceled, got: %v", err)
+		}
+	}()
+	<-started
+
+	done := make(chan struct{})
+	var (
+		name string
+		err  error
+	)
+	go func() {
+		defer close(done)
+		name, err = deduplicatedLookup(g, context.Background(), "space", "space-guid-1", lookup)
+	}()
+	waitForDeduplicated(t, g, 1)
+	cancel()
+	<-done
+	leaderWG.Wait()
+
+	if err != nil || name != "space-1" {
+		t.Errorf("expected the waiting caller to look up the space again, got: %q, %v", name, err)
+	}
+}
+
+func TestGenerateTagsDeduplicatesLookups(t *testing.T) {
+	const callers = 5
+	var (
+		spaceRequests atomic.Int32
+		release       = make(chan struct{})
+	)
+	server := newFakeCFAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/spaces/space-guid-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		spaceRequests.Add(1)
+		<-release
+		fmt.Fprint(w, `{"guid": "space-guid-1", "name": "space-1"}`)
+	})
+	tagManager, err := NewCFTagManager("AWS Broker", "testing", server.URL, "client-id", "client-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			tags, err := tagManager.GenerateTags(Create, "abc1", "abc2", ResourceGUIDs{SpaceGUID: "space-guid-1"}, false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tags[SpaceNameTagKey] != "space-1" {
+				t.Errorf("expected space name: space-1, got: %s", tags[SpaceNameTagKey])
+			}
+		}()
+	}
+	waitForDeduplicated(t, tagManager.cfResourceGetter.(*cfResourceGetter).flights, callers-1)
+	close(release)
+	wg.Wait()
+
+	if spaceRequests.Load() != 1 {
+		t.Errorf("expected 1 space request, got: %d", spaceRequests.Load())
+	}
+	if stats := tagManager.DedupStats(); stats != (DedupStats{Lookups: 1, Deduplicated: callers - 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
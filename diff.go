@@ -0,0 +1,103 @@
+package brokertags
+
+import "sort"
+
+// TagDiff describes the changes needed to bring a resource's existing tags up
+// to date with newly generated tags.
+type TagDiff struct {
+	// Add holds tags that the resource does not have yet.
+	Add map[string]string
+	// Change holds tags that the resource has with a different value.
+	Change map[string]string
+	// Remove lists the keys of tags generated by this package that the
+	// resource has but that no longer apply, since they describe a resource
+	// that another one has replaced, sorted by key.
+	Remove []string
+}
+
+// IsEmpty reports whether the diff contains no changes.
+func (d TagDiff) IsEmpty() bool {
+	return len(d.Add) == 0 && len(d.Change) == 0 && len(d.Remove) == 0
+}
+
+// resourceTagGUIDKeys maps the keys of the tags that describe a resource, such
+// as its name or quota, to the key of the GUID tag of that resource.
+var resourceTagGUIDKeys = map[string]string{
+	OrganizationNameTagKey:    OrganizationGUIDTagKey,
+	OrganizationQuotaTagKey:   OrganizationGUIDTagKey,
+	SpaceNameTagKey:           SpaceGUIDTagKey,
+	SpaceQuotaTagKey:          SpaceGUIDTagKey,
+	IsolationSegmentTagKey:    SpaceGUIDTagKey,
+	ServiceInstanceNameTagKey: ServiceInstanceGUIDTagKey,
+}
+
+// DiffTags compares the existing tags of a resource with newly generated tags,
+// for instance those generated for an Update, and returns the tags to add,
+// change and remove.
+//
+// Only tags generated by this package are ever changed or removed; tags set by
+// anyone else are left alone. Existing action timestamp and identity tags,
+// such as the "Created at" and "Created by" tags, are always kept, and the
+// "Created at" and "Created by" tags are never changed.
+//
+// A tag that was not generated this time is usually only missing because its
+// input, such as a GUID or the service plan name, was not given, or because
+// looking it up failed, as with WithBestEffort, so it is kept. It is only
+// removed when the tags of another resource replace it: a tag describing a
+// resource, such as "Space name", is removed when the GUID tag of that
+// resource, such as "Space GUID", is generated with a different value.
+func DiffTags(existing map[string]string, generated map[string]string) TagDiff {
+	diff := TagDiff{
+		Add:    make(map[string]string),
+		Change: make(map[string]string),
+	}
+
+	for key, value := range generated {
+		existingValue, ok := existing[key]
+		switch {
+		case !ok:
+			diff.Add[key] = value
+		case key == createdAtTagKey, key == createdByTagKey:
+			// The resource was created when, and by whom, it was first tagged.
+		case existingValue != value:
+			diff.Change[key] = value
+		}
+	}
+
+	for key := range existing {
+		if _, ok := generated[key]; ok {
+			continue
+		}
+		guidKey, ok := resourceTagGUIDKeys[key]
+		if !ok {
+			continue
+		}
+		if guid := generated[guidKey]; guid != "" && guid != existing[guidKey] {
+			diff.Remove = append(diff.Remove, key)
+		}
+	}
+	sort.Strings(diff.Remove)
+
+	return diff
+}
+
+// MergeTags returns the existing tags of a resource with the changes from
+// DiffTags applied. Neither existing nor generated are modified.
+func MergeTags(existing map[string]string, generated map[string]string) map[string]string {
+	diff := DiffTags(existing, generated)
+
+	merged := make(map[string]string, len(existing)+len(diff.Add))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range diff.Add {
+		merged[key] = value
+	}
+	for key, value := range diff.Change {
+		merged[key] = value
+	}
+	for _, key := range diff.Remove {
+		delete(merged, key)
+	}
+	return merged
+}
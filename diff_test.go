@@ -0,0 +1,209 @@
+package brokertags
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDiffTags(t *testing.T) {
+	testCases := map[string]struct {
+		existing       map[string]string
+		generated      map[string]string
+		expectedDiff   TagDiff
+		expectedMerged map[string]string
+	}{
+		"update": {
+			existing: map[string]string{
+				"client":        "Cloud Foundry",
+				"Created at":    "2024-01-01T00:00:00Z",
+				"Space name":    "space-1",
+				"Instance name": "instance-1",
+				"Cost center":   "abc",
+			},
+			generated: map[string]string{
+				"client":     "Cloud Foundry",
+				"Updated at": "2024-02-01T00:00:00Z",
+				"Space name": "space-2",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{
+					"Updated at": "2024-02-01T00:00:00Z",
+				},
+				Change: map[string]string{
+					"Space name": "space-2",
+				},
+			},
+			expectedMerged: map[string]string{
+				"client":        "Cloud Foundry",
+				"Created at":    "2024-01-01T00:00:00Z",
+				"Updated at":    "2024-02-01T00:00:00Z",
+				"Space name":    "space-2",
+				"Instance name": "instance-1",
+				"Cost center":   "abc",
+			},
+		},
+		"tags whose inputs were not given are kept": {
+			existing: map[string]string{
+				"Instance GUID":         "instance-guid",
+				"Instance name":         "instance-1",
+				"Organization GUID":     "org-guid",
+				"Organization name":     "org-1",
+				"Service offering name": "rds",
+				"Service plan name":     "micro",
+			},
+			generated: map[string]string{
+				"Instance GUID": "instance-guid",
+				"Instance name": "instance-1",
+				"Updated at":    "2024-02-01T00:00:00Z",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{
+					"Updated at": "2024-02-01T00:00:00Z",
+				},
+				Change: map[string]string{},
+			},
+			expectedMerged: map[string]string{
+				"Instance GUID":         "instance-guid",
+				"Instance name":         "instance-1",
+				"Organization GUID":     "org-guid",
+				"Organization name":     "org-1",
+				"Service offering name": "rds",
+				"Service plan name":     "micro",
+				"Updated at":            "2024-02-01T00:00:00Z",
+			},
+		},
+		"tags of a replaced resource are removed": {
+			existing: map[string]string{
+				"Space GUID":             "space-guid-1",
+				"Space name":             "space-1",
+				"Space quota name":       "small",
+				"Isolation segment name": "prod",
+				"Organization GUID":      "org-guid",
+				"Organization name":      "org-1",
+			},
+			generated: map[string]string{
+				"Space GUID":        "space-guid-2",
+				"Organization GUID": "org-guid",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{},
+				Change: map[string]string{
+					"Space GUID": "space-guid-2",
+				},
+				Remove: []string{"Isolation segment name", "Space name", "Space quota name"},
+			},
+			expectedMerged: map[string]string{
+				"Space GUID":        "space-guid-2",
+				"Organization GUID": "org-guid",
+				"Organization name": "org-1",
+			},
+		},
+		"created at is never changed": {
+			existing: map[string]string{
+				"Created at": "2024-01-01T00:00:00Z",
+				"Updated at": "2024-01-15T00:00:00Z",
+			},
+			generated: map[string]string{
+				"Created at": "2024-02-01T00:00:00Z",
+			},
+			expectedDiff: TagDiff{
+				Add:    map[string]string{},
+				Change: map[string]string{},
+			},
+			expectedMerged: map[string]string{
+				"Created at": "2024-01-01T00:00:00Z",
+				"Updated at": "2024-01-15T00:00:00Z",
+			},
+		},
+		"identity tags are kept": {
+			existing: map[string]string{
+				"Created at": "2024-01-01T00:00:00Z",
+				"Created by": "user-1",
+				"Updated by": "user-2",
+			},
+			generated: map[string]string{
+				"Created by": "user-3",
+				"Updated at": "2024-02-01T00:00:00Z",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{
+					"Updated at": "2024-02-01T00:00:00Z",
+				},
+				Change: map[string]string{},
+			},
+			expectedMerged: map[string]string{
+				"Created at": "2024-01-01T00:00:00Z",
+				"Created by": "user-1",
+				"Updated at": "2024-02-01T00:00:00Z",
+				"Updated by": "user-2",
+			},
+		},
+		"name tags are kept while their GUID tags are generated": {
+			existing: map[string]string{
+				"Space GUID":        "space-guid",
+				"Space name":        "space-1",
+				"Organization GUID": "org-guid",
+				"Organization name": "org-1",
+			},
+			generated: map[string]string{
+				"Space GUID":        "space-guid",
+				"Organization GUID": "org-guid",
+				"Updated at":        "2024-02-01T00:00:00Z",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{
+					"Updated at": "2024-02-01T00:00:00Z",
+				},
+				Change: map[string]string{},
+			},
+			expectedMerged: map[string]string{
+				"Space GUID":        "space-guid",
+				"Space name":        "space-1",
+				"Organization GUID": "org-guid",
+				"Organization name": "org-1",
+				"Updated at":        "2024-02-01T00:00:00Z",
+			},
+		},
+		"no existing tags": {
+			generated: map[string]string{
+				"client":     "Cloud Foundry",
+				"Created at": "2024-01-01T00:00:00Z",
+			},
+			expectedDiff: TagDiff{
+				Add: map[string]string{
+					"client":     "Cloud Foundry",
+					"Created at": "2024-01-01T00:00:00Z",
+				},
+				Change: map[string]string{},
+			},
+			expectedMerged: map[string]string{
+				"client":     "Cloud Foundry",
+				"Created at": "2024-01-01T00:00:00Z",
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			diff := DiffTags(test.existing, test.generated)
+			if !cmp.Equal(diff, test.expectedDiff, cmpopts.EquateEmpty()) {
+				t.Errorf(cmp.Diff(diff, test.expectedDiff, cmpopts.EquateEmpty()))
+			}
+			merged := MergeTags(test.existing, test.generated)
+			if !cmp.Equal(merged, test.expectedMerged) {
+				t.Errorf(cmp.Diff(merged, test.expectedMerged))
+			}
+		})
+	}
+}
+
+func TestTagDiffIsEmpty(t *testing.T) {
+	if !(TagDiff{}).IsEmpty() {
+		t.Errorf("expected empty diff to be empty")
+	}
+	if (TagDiff{Remove: []string{"Space name"}}).IsEmpty() {
+		t.Errorf("expected diff with removals not to be empty")
+	}
+}
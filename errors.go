@@ -0,0 +1,201 @@
+package brokertags
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNotFound means a resource does not exist, or is not visible to the CF
+	// API client.
+	ErrNotFound = errors.New("resource not found")
+	// ErrUnauthorized means the CF API client could not authenticate, or is not
+	// allowed to look up a resource.
+	ErrUnauthorized = errors.New("not authorized")
+	// ErrTransient means a lookup failed because of a problem that may go away
+	// if it is tried again, such as a CF API outage or rate limiting.
+	ErrTransient = errors.New("transient failure")
+)
+
+// ResourceKind is a kind of CF resource that can be looked up.
+type ResourceKind string
+
+const (
+	OrganizationResource    ResourceKind = "organization"
+	SpaceResource           ResourceKind = "space"
+	ServiceInstanceResource ResourceKind = "service instance"
+
+	OrganizationQuotaResource ResourceKind = "organization quota"
+	SpaceQuotaResource        ResourceKind = "space quota"
+	// IsolationSegmentResource lookups are of the isolation segment of a
+	// space, so the GUID of their LookupError is the space's.
+	IsolationSegmentResource ResourceKind = "isolation segment"
+)
+
+// LookupError is returned by GenerateTags when looking up a resource fails.
+// Use errors.Is with ErrNotFound, ErrUnauthorized or ErrTransient to tell
+// what kind of failure it was.
+type LookupError struct {
+	Kind ResourceKind
+	GUID string
+	Err  error
+}
+
+func (e *LookupError) Error() string {
+	return fmt.Sprintf("error getting %s %s: %s", e.Kind, e.GUID, e.Err)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LookupError) Is(target error) bool {
+	return target != nil && target == classifyError(e.Err)
+}
+
+// PartialTagsError is returned along with the tags by GenerateTags in
+// best-effort mode, enabled with WithBestEffort, when some lookups failed. The
+// tags are then missing the names of the resources that could not be looked
+// up. Each failed lookup is listed in Warnings.
+type PartialTagsError struct {
+	Warnings []*LookupError
+}
+
+func (e *PartialTagsError) Error() string {
+	warnings := make([]string, 0, len(e.Warnings))
+	for _, warning := range e.Warnings {
+		warnings = append(warnings, warning.Error())
+	}
+	return "incomplete tags: " + strings.Join(warnings, "; ")
+}
+
+func (e *PartialTagsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Warnings))
+	for _, warning := range e.Warnings {
+		errs = append(errs, warning)
+	}
+	return errs
+}
+
+// newLookupError wraps err in a LookupError, unless it already is one.
+func newLookupError(kind ResourceKind, guid string, err error) error {
+	var lookupErr *LookupError
+	if errors.As(err, &lookupErr) {
+		return err
+	}
+	return &LookupError{
+		Kind: kind,
+		GUID: guid,
+		Err:  err,
+	}
+}
+
+// classifyError returns ErrNotFound, ErrUnauthorized or ErrTransient depending
+// on the kind of failure err is, or nil if it is none of them.
+func classifyError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound),
+		resource.IsResourceNotFoundError(err),
+		resource.IsNotFoundError(err):
+		return ErrNotFound
+	case errors.Is(err, ErrUnauthorized),
+		resource.IsInvalidAuthTokenError(err),
+		resource.IsNotAuthenticatedError(err),
+		resource.IsNotAuthorizedError(err),
+		resource.IsInsufficientScopeError(err):
+		return ErrUnauthorized
+	case errors.Is(err, ErrTransient),
+		resource.IsServerError(err),
+		resource.IsServiceUnavailableError(err),
+		resource.IsRateLimitExceededError(err),
+		resource.IsIPBasedRateLimitExceededError(err),
+		resource.IsUAAUnavailableError(err):
+		return ErrTransient
+	}
+
+	if statusCode, ok := httpStatusCode(err); ok {
+		switch {
+		case statusCode == http.StatusNotFound:
+			return ErrNotFound
+		case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+			return ErrUnauthorized
+		case statusCode == http.StatusTooManyRequests, statusCode >= 500:
+			return ErrTransient
+		}
+		return nil
+	}
+
+	// Every *url.Error is a net.Error, whatever the cause, so network errors
+	// are only transient if they timed out or the connection failed. Unknown
+	// hosts and invalid certificates will fail the same way again.
+	if isPermanentNetworkError(err) {
+		return nil
+	}
+	var netErr net.Error
+	if (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		isConnectionClosed(err) {
+		return ErrTransient
+	}
+	return nil
+}
+
+// isConnectionClosed reports whether err is a request that failed because the
+// server closed the connection before responding.
+func isConnectionClosed(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF)
+}
+
+// isPermanentNetworkError reports whether err is a failed request that will
+// not succeed if it is tried again: one canceled by the caller, to a host that
+// does not exist, or to a server whose certificate is not trusted.
+func isPermanentNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true
+	}
+	var (
+		verificationErr  *tls.CertificateVerificationError
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+	)
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalidCert) ||
+		errors.As(err, &hostnameErr)
+}
+
+// httpStatusCode returns the HTTP status code of a failed request to the CF
+// API or UAA, if err carries one.
+func httpStatusCode(err error) (int, bool) {
+	var httpErr resource.CloudFoundryHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode, true
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+		return retrieveErr.Response.StatusCode, true
+	}
+	return 0, false
+}
@@ -0,0 +1,222 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"golang.org/x/oauth2"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := map[string]struct {
+		err           error
+		expectedClass error
+	}{
+		"resource not found": {
+			err:           resource.NewResourceNotFoundError(),
+			expectedClass: ErrNotFound,
+		},
+		"wrapped resource not found": {
+			err:           fmt.Errorf("error executing GET request: %w", resource.NewResourceNotFoundError()),
+			expectedClass: ErrNotFound,
+		},
+		"HTTP 404": {
+			err:           resource.CloudFoundryHTTPError{StatusCode: http.StatusNotFound},
+			expectedClass: ErrNotFound,
+		},
+		"invalid auth token": {
+			err:           resource.NewInvalidAuthTokenError(),
+			expectedClass: ErrUnauthorized,
+		},
+		"not authorized": {
+			err:           resource.NewNotAuthorizedError(),
+			expectedClass: ErrUnauthorized,
+		},
+		"UAA rejected credentials": {
+			err: &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusUnauthorized},
+			},
+			expectedClass: ErrUnauthorized,
+		},
+		"server error": {
+			err:           resource.NewServerError(),
+			expectedClass: ErrTransient,
+		},
+		"rate limited": {
+			err:           resource.NewRateLimitExceededError(),
+			expectedClass: ErrTransient,
+		},
+		"HTTP 503": {
+			err:           resource.CloudFoundryHTTPError{StatusCode: http.StatusServiceUnavailable},
+			expectedClass: ErrTransient,
+		},
+		"connection error": {
+			err:           &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			expectedClass: ErrTransient,
+		},
+		"HTTP 400": {
+			err: resource.CloudFoundryHTTPError{StatusCode: http.StatusBadRequest},
+		},
+		"other error": {
+			err: errors.New("something else"),
+		},
+		"canceled": {
+			err: context.Canceled,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			class := classifyError(test.err)
+			if class != test.expectedClass {
+				t.Errorf("expected class: %v, got: %v", test.expectedClass, class)
+			}
+		})
+	}
+}
+
+// TestClassifyRequestError classifies the errors of real failed requests,
+// which the http.Client always wraps in a *url.Error.
+func TestClassifyRequestError(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slowServer.Close()
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := map[string]struct {
+		ctx           context.Context
+		client        *http.Client
+		url           string
+		expectedClass error
+	}{
+		"canceled": {
+			ctx: canceledCtx,
+			url: slowServer.URL,
+		},
+		"unknown host": {
+			url: "http://broker-tags-test.invalid/",
+		},
+		"untrusted certificate": {
+			url: tlsServer.URL,
+		},
+		"unsupported protocol scheme": {
+			url: "ftp://example.com/",
+		},
+		"timeout": {
+			client:        &http.Client{Timeout: 10 * time.Millisecond},
+			url:           slowServer.URL,
+			expectedClass: ErrTransient,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := test.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			client := test.client
+			if client == nil {
+				client = &http.Client{}
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("expected the request to fail")
+			}
+			var dnsErr *net.DNSError
+			if name == "unknown host" && (!errors.As(err, &dnsErr) || !dnsErr.IsNotFound) {
+				t.Skipf("DNS is not available: %s", err)
+			}
+			class := classifyError(err)
+			if class != test.expectedClass {
+				t.Errorf("expected class: %v, got: %v (%s)", test.expectedClass, class, err)
+			}
+		})
+	}
+}
+
+func TestGenerateTagsLookupErrors(t *testing.T) {
+	testCases := map[string]struct {
+		mockResourceGetter *mockCFClientWrapper
+		expectedKind       ResourceKind
+		expectedGUID       string
+		expectedClass      error
+	}{
+		"space not found": {
+			mockResourceGetter: &mockCFClientWrapper{
+				getSpaceErr: resource.NewResourceNotFoundError(),
+			},
+			expectedKind:  SpaceResource,
+			expectedGUID:  "space-1",
+			expectedClass: ErrNotFound,
+		},
+		"organization unauthorized": {
+			mockResourceGetter: &mockCFClientWrapper{
+				getOrganizationErr: resource.NewInvalidAuthTokenError(),
+			},
+			expectedKind:  OrganizationResource,
+			expectedGUID:  "org-1",
+			expectedClass: ErrUnauthorized,
+		},
+		"instance transient": {
+			mockResourceGetter: &mockCFClientWrapper{
+				getServiceInstanceErr: resource.NewServiceUnavailableError(),
+			},
+			expectedKind:  ServiceInstanceResource,
+			expectedGUID:  "instance-1",
+			expectedClass: ErrTransient,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager := &CfTagManager{
+				cfResourceGetter: test.mockResourceGetter,
+			}
+			_, err := tagManager.GenerateTags(
+				Update,
+				"abc1",
+				"abc2",
+				ResourceGUIDs{
+					OrganizationGUID: "org-1",
+					SpaceGUID:        "space-1",
+					InstanceGUID:     "instance-1",
+				},
+				false,
+			)
+
+			var lookupErr *LookupError
+			if !errors.As(err, &lookupErr) {
+				t.Fatalf("expected a lookup error, got: %s", err)
+			}
+			if lookupErr.Kind != test.expectedKind || lookupErr.GUID != test.expectedGUID {
+				t.Errorf("expected lookup error for %s %s, got: %s %s", test.expectedKind, test.expectedGUID, lookupErr.Kind, lookupErr.GUID)
+			}
+			for _, class := range []error{ErrNotFound, ErrUnauthorized, ErrTransient} {
+				if errors.Is(err, class) != (class == test.expectedClass) {
+					t.Errorf("expected errors.Is(err, %v) to be %t", class, class == test.expectedClass)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ go 1.20
 require (
 	github.com/cloudfoundry/go-cfclient/v3 v3.0.0-alpha.9
 	github.com/google/go-cmp v0.6.0
+	golang.org/x/oauth2 v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,6 +17,4 @@ require (
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
-	golang.org/x/oauth2 v0.21.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
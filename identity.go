@@ -0,0 +1,68 @@
+package brokertags
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OriginatingIdentityHeader is the OSB header that identifies the platform
+// user who made a request.
+const OriginatingIdentityHeader = "X-Broker-API-Originating-Identity"
+
+// ErrInvalidOriginatingIdentity is wrapped by the errors that
+// ParseOriginatingIdentity returns for malformed headers.
+var ErrInvalidOriginatingIdentity = errors.New("invalid originating identity")
+
+// OriginatingIdentity is the platform user who made an OSB request.
+type OriginatingIdentity struct {
+	// Platform is the platform the user belongs to, such as "cloudfoundry".
+	Platform string
+	// UserID identifies the user: the user_id for Cloud Foundry, or the
+	// username for Kubernetes.
+	UserID string
+	// Value holds every property of the identity as sent by the platform.
+	Value map[string]any
+}
+
+// ParseOriginatingIdentity parses the value of the
+// X-Broker-API-Originating-Identity header, which is the platform followed by
+// a space and a base64 encoded JSON object identifying the user. Errors for
+// malformed values wrap ErrInvalidOriginatingIdentity.
+func ParseOriginatingIdentity(header string) (*OriginatingIdentity, error) {
+	platform, encodedValue, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || platform == "" || encodedValue == "" {
+		return nil, fmt.Errorf("%w: expected a platform and a base64 encoded value separated by a space, got %q", ErrInvalidOriginatingIdentity, header)
+	}
+
+	rawValue, err := base64.StdEncoding.DecodeString(encodedValue)
+	if err != nil {
+		// The padding is optional in practice.
+		rawValue, err = base64.RawStdEncoding.DecodeString(encodedValue)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: value is not valid base64: %s", ErrInvalidOriginatingIdentity, err)
+	}
+
+	var value map[string]any
+	if err := json.Unmarshal(rawValue, &value); err != nil {
+		return nil, fmt.Errorf("%w: value is not a JSON object: %s", ErrInvalidOriginatingIdentity, err)
+	}
+
+	userIDProperty := "user_id"
+	if platform == KubernetesPlatform {
+		userIDProperty = "username"
+	}
+	userID, _ := value[userIDProperty].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("%w: value has no %s", ErrInvalidOriginatingIdentity, userIDProperty)
+	}
+
+	return &OriginatingIdentity{
+		Platform: platform,
+		UserID:   userID,
+		Value:    value,
+	}, nil
+}
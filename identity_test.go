@@ -0,0 +1,141 @@
+package brokertags
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOriginatingIdentity(t *testing.T) {
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	testCases := map[string]struct {
+		header           string
+		expectedIdentity *OriginatingIdentity
+		expectedErr      string
+	}{
+		"cloudfoundry": {
+			header: "cloudfoundry " + encode(`{"user_id": "683ea748-3092-4ff4-b656-39cacc4d5360"}`),
+			expectedIdentity: &OriginatingIdentity{
+				Platform: "cloudfoundry",
+				UserID:   "683ea748-3092-4ff4-b656-39cacc4d5360",
+				Value:    map[string]any{"user_id": "683ea748-3092-4ff4-b656-39cacc4d5360"},
+			},
+		},
+		"kubernetes": {
+			header: "kubernetes " + encode(`{"username": "duke", "uid": "c2dde242-5ce4-11e7-988c-000c2946f14f"}`),
+			expectedIdentity: &OriginatingIdentity{
+				Platform: "kubernetes",
+				UserID:   "duke",
+				Value:    map[string]any{"username": "duke", "uid": "c2dde242-5ce4-11e7-988c-000c2946f14f"},
+			},
+		},
+		"unpadded base64": {
+			header: "cloudfoundry " + base64.RawStdEncoding.EncodeToString([]byte(`{"user_id":"u"}`)),
+			expectedIdentity: &OriginatingIdentity{
+				Platform: "cloudfoundry",
+				UserID:   "u",
+				Value:    map[string]any{"user_id": "u"},
+			},
+		},
+		"empty": {
+			header:      "",
+			expectedErr: `invalid originating identity: expected a platform and a base64 encoded value separated by a space, got ""`,
+		},
+		"no value": {
+			header:      "cloudfoundry",
+			expectedErr: `invalid originating identity: expected a platform and a base64 encoded value separated by a space, got "cloudfoundry"`,
+		},
+		"invalid base64": {
+			header:      "cloudfoundry not-base64!",
+			expectedErr: "invalid originating identity: value is not valid base64: illegal base64 data at input byte 3",
+		},
+		"invalid JSON": {
+			header:      "cloudfoundry " + encode(`"user"`),
+			expectedErr: "invalid originating identity: value is not a JSON object: json: cannot unmarshal string into Go value of type map[string]interface {}",
+		},
+		"no user ID": {
+			header:      "cloudfoundry " + encode(`{"username": "duke"}`),
+			expectedErr: "invalid originating identity: value has no user_id",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			identity, err := ParseOriginatingIdentity(test.header)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				if !errors.Is(err, ErrInvalidOriginatingIdentity) {
+					t.Errorf("expected error to wrap ErrInvalidOriginatingIdentity")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cmp.Equal(identity, test.expectedIdentity) {
+				t.Errorf(cmp.Diff(identity, test.expectedIdentity))
+			}
+		})
+	}
+}
+
+func TestGenerateTagsWithOriginatingIdentity(t *testing.T) {
+	identity := &OriginatingIdentity{Platform: "cloudfoundry", UserID: "user-1"}
+
+	testCases := map[string]struct {
+		action         Action
+		identity       *OriginatingIdentity
+		expectedTagKey string
+	}{
+		"create": {
+			action:         Create,
+			identity:       identity,
+			expectedTagKey: "Created by",
+		},
+		"update": {
+			action:         Update,
+			identity:       identity,
+			expectedTagKey: "Updated by",
+		},
+		"delete": {
+			action:         Delete,
+			identity:       identity,
+			expectedTagKey: "Deleted by",
+		},
+		"no identity": {
+			action: Create,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager := &CfTagManager{cfResourceGetter: &mockCFClientWrapper{}}
+			tags, err := tagManager.GenerateTags(test.action, "abc1", "abc2", ResourceGUIDs{}, false, WithOriginatingIdentity(test.identity))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var identityTags []string
+			for key := range tags {
+				if identityTagKeys[key] {
+					identityTags = append(identityTags, key)
+				}
+			}
+			if test.expectedTagKey == "" {
+				if len(identityTags) > 0 {
+					t.Errorf("expected no identity tags, got: %v", identityTags)
+				}
+				return
+			}
+			if len(identityTags) != 1 || tags[test.expectedTagKey] != "user-1" {
+				t.Errorf("expected only the %s tag with user-1, got: %v", test.expectedTagKey, tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package brokertags
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	labelMaxLength       = 63
+	labelMaxPrefixLength = 253
+)
+
+// labelKeys maps the keys of generated tags to their canonical label keys.
+var labelKeys = map[string]string{
+	BrokerTagKey:              "broker",
+	ClientTagKey:              "client",
+	EnvironmentTagKey:         "environment",
+	OrganizationGUIDTagKey:    "organization-guid",
+	OrganizationNameTagKey:    "organization-name",
+	ServiceInstanceGUIDTagKey: "instance-guid",
+	ServiceInstanceNameTagKey: "instance-name",
+	ServiceNameTagKey:         "service-offering-name",
+	ServicePlanName:           "service-plan-name",
+	SpaceGUIDTagKey:           "space-guid",
+	SpaceNameTagKey:           "space-name",
+	NamespaceTagKey:           "namespace",
+	ClusterIDTagKey:           "cluster-id",
+	OrganizationQuotaTagKey:   "organization-quota-name",
+	SpaceQuotaTagKey:          "space-quota-name",
+	IsolationSegmentTagKey:    "isolation-segment-name",
+	createdAtTagKey:           "created-at",
+	updatedAtTagKey:           "updated-at",
+	boundAtTagKey:             "bound-at",
+	unboundAtTagKey:           "unbound-at",
+	deletedAtTagKey:           "deleted-at",
+	restoredAtTagKey:          "restored-at",
+	createdByTagKey:           "created-by",
+	updatedByTagKey:           "updated-by",
+	boundByTagKey:             "bound-by",
+	unboundByTagKey:           "unbound-by",
+	deletedByTagKey:           "deleted-by",
+	restoredByTagKey:          "restored-by",
+}
+
+var (
+	// labelInvalidCharacters matches runs of characters that are not valid in
+	// both Kubernetes and GCP label keys and values.
+	labelInvalidCharacters = regexp.MustCompile(`[^a-z0-9_-]+`)
+	labelPrefixPattern     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// FormatLabels converts tags, such as those returned by GenerateTags, into
+// Kubernetes labels. Without a prefix, the labels of generated tags are also
+// valid on GCP resources.
+//
+// Keys of generated tags are mapped to canonical label keys, such as
+// "organization-guid" for OrganizationGUIDTagKey; any other key is lowercased
+// and has invalid characters replaced with dashes, and may then start with a
+// digit, which GCP does not allow. If prefix is not empty, it is prepended to
+// each label key, e.g. "cloud.gov/organization-guid"; prefixed keys are only
+// valid on Kubernetes.
+//
+// Values are lowercased and have invalid characters replaced with dashes.
+// Whenever that changes a value, the original value is also returned in the
+// annotations under the same key. Values that cannot be represented as a label
+// at all, such as those longer than 63 characters, are only returned in the
+// annotations.
+func FormatLabels(tags map[string]string, prefix string) (labels map[string]string, annotations map[string]string, err error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && (len(prefix) > labelMaxPrefixLength || !labelPrefixPattern.MatchString(prefix)) {
+		return nil, nil, fmt.Errorf("label prefix %q is not a valid DNS subdomain", prefix)
+	}
+
+	labels = make(map[string]string, len(tags))
+	annotations = make(map[string]string)
+	seen := make(map[string]string, len(tags))
+
+	// Keys are formatted in order, so that the error for colliding keys is
+	// always the same.
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := tags[key]
+		labelKey, ok := labelKeys[key]
+		if !ok {
+			labelKey = toLabel(key)
+		}
+		if labelKey == "" || len(labelKey) > labelMaxLength {
+			return nil, nil, fmt.Errorf("tag key %q cannot be represented as a label key", key)
+		}
+		if original, ok := seen[labelKey]; ok {
+			return nil, nil, fmt.Errorf("tag keys %q and %q are both formatted as label key %q", original, key, labelKey)
+		}
+		seen[labelKey] = key
+		if prefix != "" {
+			labelKey = prefix + "/" + labelKey
+		}
+
+		labelValue := toLabel(value)
+		if len(labelValue) > labelMaxLength || (labelValue == "" && value != "") {
+			annotations[labelKey] = value
+			continue
+		}
+		labels[labelKey] = labelValue
+		if labelValue != value {
+			annotations[labelKey] = value
+		}
+	}
+	return labels, annotations, nil
+}
+
+// toLabel lowercases s, replaces any characters that are not valid in a label
+// with dashes and trims characters that a label cannot start or end with.
+func toLabel(s string) string {
+	s = labelInvalidCharacters.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-_")
+}
@@ -0,0 +1,109 @@
+package brokertags
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatLabels(t *testing.T) {
+	testCases := map[string]struct {
+		tags                map[string]string
+		prefix              string
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+		expectedErr         error
+	}{
+		"generated tags": {
+			tags: map[string]string{
+				"client":                "Cloud Foundry",
+				"environment":           "testing",
+				"Organization GUID":     "4bd3ba4c-0b0b-4b42-a3c7-3b4b0b4a0e8f",
+				"Service offering name": "rds",
+				"Service plan name":     "micro-psql",
+				"Created at":            "2024-01-02T03:04:05Z",
+			},
+			expectedLabels: map[string]string{
+				"client":                "cloud-foundry",
+				"environment":           "testing",
+				"organization-guid":     "4bd3ba4c-0b0b-4b42-a3c7-3b4b0b4a0e8f",
+				"service-offering-name": "rds",
+				"service-plan-name":     "micro-psql",
+				"created-at":            "2024-01-02t03-04-05z",
+			},
+			expectedAnnotations: map[string]string{
+				"client":     "Cloud Foundry",
+				"created-at": "2024-01-02T03:04:05Z",
+			},
+		},
+		"prefix": {
+			tags: map[string]string{
+				"Space name": "space-1",
+			},
+			prefix: "cloud.gov/",
+			expectedLabels: map[string]string{
+				"cloud.gov/space-name": "space-1",
+			},
+			expectedAnnotations: map[string]string{},
+		},
+		"other keys": {
+			tags: map[string]string{
+				"Cost Center": "abc",
+			},
+			expectedLabels: map[string]string{
+				"cost-center": "abc",
+			},
+			expectedAnnotations: map[string]string{},
+		},
+		"values that cannot be labels": {
+			tags: map[string]string{
+				"Instance name": strings.Repeat("a", 64),
+				"Space name":    "***",
+			},
+			expectedLabels: map[string]string{},
+			expectedAnnotations: map[string]string{
+				"instance-name": strings.Repeat("a", 64),
+				"space-name":    "***",
+			},
+		},
+		"invalid prefix": {
+			tags:        map[string]string{},
+			prefix:      "Cloud Gov",
+			expectedErr: errors.New(`label prefix "Cloud Gov" is not a valid DNS subdomain`),
+		},
+		"invalid key": {
+			tags: map[string]string{
+				"***": "abc",
+			},
+			expectedErr: errors.New(`tag key "***" cannot be represented as a label key`),
+		},
+		"keys collide": {
+			tags: map[string]string{
+				"Space name": "space-1",
+				"space-name": "space-2",
+			},
+			expectedErr: errors.New(`tag keys "Space name" and "space-name" are both formatted as label key "space-name"`),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			labels, annotations, err := FormatLabels(test.tags, test.prefix)
+			if (test.expectedErr != nil && err == nil) ||
+				(err != nil && !strings.Contains(err.Error(), test.expectedErr.Error())) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if test.expectedErr != nil {
+				return
+			}
+			if !cmp.Equal(labels, test.expectedLabels) {
+				t.Errorf(cmp.Diff(labels, test.expectedLabels))
+			}
+			if !cmp.Equal(annotations, test.expectedAnnotations) {
+				t.Errorf(cmp.Diff(annotations, test.expectedAnnotations))
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package brokertags
+
+import (
+	"errors"
+	"sync"
+)
+
+// defaultMaxConcurrentLookups is enough for the service instance, space and
+// organization of a call to GenerateTags to be looked up at once.
+const defaultMaxConcurrentLookups = 3
+
+// lookupGroup runs lookups concurrently, at most a limited number at a time.
+type lookupGroup struct {
+	wg    sync.WaitGroup
+	slots chan struct{}
+}
+
+// newLookupGroup returns a lookupGroup that runs at most limit lookups at a
+// time, or defaultMaxConcurrentLookups if limit is not positive.
+func newLookupGroup(limit int) *lookupGroup {
+	if limit <= 0 {
+		limit = defaultMaxConcurrentLookups
+	}
+	return &lookupGroup{
+		slots: make(chan struct{}, limit),
+	}
+}
+
+// Go runs lookup in a new goroutine once a slot is free.
+func (g *lookupGroup) Go(lookup func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.slots <- struct{}{}
+		defer func() { <-g.slots }()
+		lookup()
+	}()
+}
+
+// Wait waits for every lookup started with Go to return.
+func (g *lookupGroup) Wait() {
+	g.wg.Wait()
+}
+
+// joinErrors returns the only error in errs as is, or else joins them in
+// order with errors.Join.
+func joinErrors(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
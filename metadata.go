@@ -0,0 +1,119 @@
+package brokertags
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// MetadataTagConfig selects which CF metadata labels and annotations are
+// copied into the generated tags, and under which keys.
+type MetadataTagConfig struct {
+	// Keys lists metadata keys to copy, such as "cloud.gov/agency".
+	Keys []string
+	// Prefixes lists prefixes of metadata keys to copy, such as "cloud.gov/".
+	Prefixes []string
+	// KeyMapping maps metadata keys to the keys of the tags they are copied to.
+	// Metadata keys without a mapping are copied to tags with the same key.
+	KeyMapping map[string]string
+}
+
+// addTags copies the allowed metadata of the organization, space and service
+// instance into tags, in that order, so that metadata of the more specific
+// resource wins when the same key is set on more than one. Annotations win
+// over labels with the same key, and when several keys of one resource are
+// copied to the same tag, the last of them in key order wins. Metadata is
+// never copied over the tags generated by this package.
+func (c *MetadataTagConfig) addTags(
+	tags map[string]string,
+	organization *resource.Organization,
+	space *resource.Space,
+	instance *resource.ServiceInstance,
+) {
+	var metadata []*resource.Metadata
+	if organization != nil {
+		metadata = append(metadata, organization.Metadata)
+	}
+	if space != nil {
+		metadata = append(metadata, space.Metadata)
+	}
+	if instance != nil {
+		metadata = append(metadata, instance.Metadata)
+	}
+
+	for _, m := range metadata {
+		if m == nil {
+			continue
+		}
+		c.copyAllowed(tags, m.Labels)
+		c.copyAllowed(tags, m.Annotations)
+	}
+}
+
+func (c *MetadataTagConfig) copyAllowed(tags map[string]string, values map[string]*string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		if value == nil || !c.allows(key) {
+			continue
+		}
+		tagKey := key
+		if mapped, ok := c.KeyMapping[key]; ok {
+			tagKey = mapped
+		}
+		if managedTagKeys[tagKey] {
+			continue
+		}
+		tags[tagKey] = *value
+	}
+}
+
+// reservesTagKey reports whether metadata may be copied to the tag with the
+// given key, ignoring case. c may be nil.
+func (c *MetadataTagConfig) reservesTagKey(key string) bool {
+	if c == nil {
+		return false
+	}
+	for metadataKey, tagKey := range c.KeyMapping {
+		if strings.EqualFold(key, tagKey) && c.allows(metadataKey) {
+			return true
+		}
+	}
+	for metadataKey := range c.KeyMapping {
+		if strings.EqualFold(key, metadataKey) {
+			// Metadata with this key is copied to another tag.
+			return false
+		}
+	}
+	for _, allowed := range c.Keys {
+		if strings.EqualFold(key, allowed) {
+			return true
+		}
+	}
+	for _, prefix := range c.Prefixes {
+		if strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MetadataTagConfig) allows(key string) bool {
+	for _, allowed := range c.Keys {
+		if key == allowed {
+			return true
+		}
+	}
+	for _, prefix := range c.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
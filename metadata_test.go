@@ -0,0 +1,175 @@
+package brokertags
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerateTagsWithMetadataTags(t *testing.T) {
+	testCases := map[string]struct {
+		metadataTags  MetadataTagConfig
+		resourceGUIDs ResourceGUIDs
+		expectedTags  map[string]string
+	}{
+		"exact keys and prefixes": {
+			metadataTags: MetadataTagConfig{
+				Keys:     []string{"team"},
+				Prefixes: []string{"cloud.gov/"},
+			},
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID:     "abc5",
+				SpaceGUID:        "abc4",
+				OrganizationGUID: "abc3",
+			},
+			expectedTags: map[string]string{
+				"cloud.gov/agency":      "gsa",
+				"cloud.gov/cost-center": "space-cost-center",
+				"team":                  "team-1",
+			},
+		},
+		"key mapping": {
+			metadataTags: MetadataTagConfig{
+				Keys: []string{"cloud.gov/agency", "team"},
+				KeyMapping: map[string]string{
+					"cloud.gov/agency": "Agency",
+				},
+			},
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID:     "abc5",
+				SpaceGUID:        "abc4",
+				OrganizationGUID: "abc3",
+			},
+			expectedTags: map[string]string{
+				"Agency": "gsa",
+				"team":   "team-1",
+			},
+		},
+		"metadata cannot override generated tags": {
+			metadataTags: MetadataTagConfig{
+				Keys: []string{"team"},
+				KeyMapping: map[string]string{
+					"team": "broker",
+				},
+			},
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID:     "abc5",
+				SpaceGUID:        "abc4",
+				OrganizationGUID: "abc3",
+			},
+			expectedTags: map[string]string{},
+		},
+		"instance metadata when only the instance GUID is known": {
+			metadataTags: MetadataTagConfig{
+				Keys: []string{"team"},
+			},
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID: "abc5",
+			},
+			expectedTags: map[string]string{
+				"team": "team-1",
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockResourceGetter := &mockCFClientWrapper{
+				organizationName: "org-1",
+				spaceName:        "space-1",
+				spaceGUID:        "abc4",
+				organizationGUID: "abc3",
+				instanceGUID:     "abc5",
+				instanceName:     "abc6",
+				organizationMetadata: resource.NewMetadata().
+					WithLabel("cloud.gov", "agency", "gsa").
+					WithAnnotation("cloud.gov", "cost-center", "org-cost-center"),
+				spaceMetadata: resource.NewMetadata().
+					WithAnnotation("cloud.gov", "cost-center", "space-cost-center"),
+				instanceMetadata: resource.NewMetadata().
+					WithLabel("", "team", "team-1"),
+			}
+			tagManager := &CfTagManager{
+				broker:           "AWS Broker",
+				cfResourceGetter: mockResourceGetter,
+				metadataTags:     &test.metadataTags,
+			}
+
+			tags, err := tagManager.GenerateTags(
+				Create,
+				"",
+				"",
+				test.resourceGUIDs,
+				false,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for key := range managedTagKeys {
+				delete(tags, key)
+			}
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+			if mockResourceGetter.getIncludesCallCount != 0 {
+				t.Errorf("expected no calls to getServiceInstanceIncludeSpaceAndOrganization, got %d", mockResourceGetter.getIncludesCallCount)
+			}
+		})
+	}
+}
+
+func TestMetadataTagConfigAddTagsKeyOrder(t *testing.T) {
+	config := &MetadataTagConfig{
+		Prefixes: []string{"a/"},
+		KeyMapping: map[string]string{
+			"a/x": "Agency",
+			"a/y": "Agency",
+		},
+	}
+	organization := &resource.Organization{
+		Metadata: resource.NewMetadata().
+			WithLabel("a", "x", "from-x").
+			WithLabel("a", "y", "from-y"),
+	}
+
+	for i := 0; i < 20; i++ {
+		tags := make(map[string]string)
+		config.addTags(tags, organization, nil, nil)
+		if tags["Agency"] != "from-y" {
+			t.Fatalf("expected the last metadata key to win, got tags: %v", tags)
+		}
+	}
+}
+
+func TestMetadataTagConfigReservesTagKey(t *testing.T) {
+	config := &MetadataTagConfig{
+		Keys:     []string{"team", "cloud.gov/agency", "unmapped"},
+		Prefixes: []string{"cloud.gov/"},
+		KeyMapping: map[string]string{
+			"cloud.gov/agency": "Agency",
+			"other":            "Other",
+		},
+	}
+
+	testCases := map[string]bool{
+		"team":                  true,
+		"TEAM":                  true,
+		"Agency":                true,
+		"cloud.gov/cost-center": true,
+		"cloud.gov/agency":      false,
+		"Other":                 false,
+		"Cost center":           false,
+	}
+	for key, expected := range testCases {
+		if reserved := config.reservesTagKey(key); reserved != expected {
+			t.Errorf("expected reservesTagKey(%q) to be %t, got %t", key, expected, reserved)
+		}
+	}
+
+	var nilConfig *MetadataTagConfig
+	if nilConfig.reservesTagKey("team") {
+		t.Error("expected a nil config to reserve no keys")
+	}
+}
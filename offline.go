@@ -0,0 +1,239 @@
+package brokertags
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceFixture holds the organizations, spaces and service instances that
+// an offline CfTagManager, created by NewOfflineTagManager, takes names and
+// metadata from.
+type ResourceFixture struct {
+	Organizations    []FixtureOrganization    `yaml:"organizations"`
+	Spaces           []FixtureSpace           `yaml:"spaces"`
+	ServiceInstances []FixtureServiceInstance `yaml:"service_instances"`
+}
+
+// FixtureOrganization is an organization in a ResourceFixture.
+type FixtureOrganization struct {
+	GUID        string            `yaml:"guid"`
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// FixtureSpace is a space in a ResourceFixture.
+type FixtureSpace struct {
+	GUID             string            `yaml:"guid"`
+	Name             string            `yaml:"name"`
+	OrganizationGUID string            `yaml:"organization_guid"`
+	Labels           map[string]string `yaml:"labels"`
+	Annotations      map[string]string `yaml:"annotations"`
+}
+
+// FixtureServiceInstance is a service instance in a ResourceFixture.
+type FixtureServiceInstance struct {
+	GUID        string            `yaml:"guid"`
+	Name        string            `yaml:"name"`
+	SpaceGUID   string            `yaml:"space_guid"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// LoadResourceFixture reads a ResourceFixture from a YAML or JSON file.
+func LoadResourceFixture(path string) (*ResourceFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading resource fixture: %w", err)
+	}
+	return ParseResourceFixture(data)
+}
+
+// ParseResourceFixture parses a ResourceFixture from YAML or JSON, which is
+// also valid YAML. Unknown fields, resources without a GUID and duplicate
+// GUIDs are errors.
+func ParseResourceFixture(data []byte) (*ResourceFixture, error) {
+	fixture := &ResourceFixture{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(fixture); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing resource fixture: %w", err)
+	}
+	if _, err := newOfflineResourceGetter(fixture); err != nil {
+		return nil, err
+	}
+	return fixture, nil
+}
+
+// NewOfflineTagManager creates a CfTagManager that never contacts the CF API,
+// for running brokers locally or where the CF API cannot be reached. Names and
+// metadata are taken from fixture, which may be nil.
+//
+// A resource that is not in the fixture is given its GUID as its name, so
+// that the same tag keys are generated as with the CF API. Without the
+// fixture, the organization of a space and the space of a service instance
+// are unknown, so their tags are only generated when their GUIDs are passed.
+//
+// Options that configure the CF API client, and WithResourceGetter, are
+// ignored. WithQuotaTags is not supported, since the fixture has no quotas.
+func NewOfflineTagManager(broker string, environment string, fixture *ResourceFixture, opts ...Option) (*CfTagManager, error) {
+	resourceGetter, err := newOfflineResourceGetter(fixture)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	if err := checkQuotaGetter(resourceGetter, o); err != nil {
+		return nil, err
+	}
+	return newCfTagManager(broker, environment, resourceGetter, o), nil
+}
+
+// offlineResourceGetter is a ResourceGetter that serves the resources of a
+// ResourceFixture, and a placeholder for any other resource.
+type offlineResourceGetter struct {
+	organizations    map[string]*resource.Organization
+	spaces           map[string]*resource.Space
+	serviceInstances map[string]*resource.ServiceInstance
+}
+
+func newOfflineResourceGetter(fixture *ResourceFixture) (*offlineResourceGetter, error) {
+	o := &offlineResourceGetter{
+		organizations:    make(map[string]*resource.Organization),
+		spaces:           make(map[string]*resource.Space),
+		serviceInstances: make(map[string]*resource.ServiceInstance),
+	}
+	if fixture == nil {
+		return o, nil
+	}
+
+	for _, f := range fixture.Organizations {
+		if err := checkFixtureGUID(o.organizations, "organization", f.GUID); err != nil {
+			return nil, err
+		}
+		o.organizations[f.GUID] = newOfflineOrganization(f.GUID, f.Name)
+		o.organizations[f.GUID].Metadata = newFixtureMetadata(f.Labels, f.Annotations)
+	}
+	for _, f := range fixture.Spaces {
+		if err := checkFixtureGUID(o.spaces, "space", f.GUID); err != nil {
+			return nil, err
+		}
+		o.spaces[f.GUID] = newOfflineSpace(f.GUID, f.Name, f.OrganizationGUID)
+		o.spaces[f.GUID].Metadata = newFixtureMetadata(f.Labels, f.Annotations)
+	}
+	for _, f := range fixture.ServiceInstances {
+		if err := checkFixtureGUID(o.serviceInstances, "service instance", f.GUID); err != nil {
+			return nil, err
+		}
+		o.serviceInstances[f.GUID] = newOfflineServiceInstance(f.GUID, f.Name, f.SpaceGUID)
+		o.serviceInstances[f.GUID].Metadata = newFixtureMetadata(f.Labels, f.Annotations)
+	}
+	return o, nil
+}
+
+func checkFixtureGUID[T any](resources map[string]*T, kind string, guid string) error {
+	if guid == "" {
+		return fmt.Errorf("resource fixture has a %s without a GUID", kind)
+	}
+	if _, ok := resources[guid]; ok {
+		return fmt.Errorf("resource fixture has duplicate %s GUID: %s", kind, guid)
+	}
+	return nil
+}
+
+func (o *offlineResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	if organization, ok := o.organizations[organizationGUID]; ok {
+		return organization, nil
+	}
+	return newOfflineOrganization(organizationGUID, organizationGUID), nil
+}
+
+func (o *offlineResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	if space, ok := o.spaces[spaceGUID]; ok {
+		return space, nil
+	}
+	return newOfflineSpace(spaceGUID, spaceGUID, ""), nil
+}
+
+func (o *offlineResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	if instance, ok := o.serviceInstances[instanceGUID]; ok {
+		return instance, nil
+	}
+	return newOfflineServiceInstance(instanceGUID, instanceGUID, ""), nil
+}
+
+func (o *offlineResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	instance, _ := o.GetServiceInstance(ctx, instanceGUID)
+	space := o.spaces[instance.Relationships.Space.Data.GUID]
+	var organization *resource.Organization
+	if space != nil {
+		organization = o.organizations[space.Relationships.Organization.Data.GUID]
+	}
+	return instance, space, organization, nil
+}
+
+func newOfflineOrganization(guid string, name string) *resource.Organization {
+	return &resource.Organization{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+	}
+}
+
+func newOfflineSpace(guid string, name string, organizationGUID string) *resource.Space {
+	return &resource.Space{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+		Relationships: &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: organizationGUID,
+				},
+			},
+		},
+	}
+}
+
+func newOfflineServiceInstance(guid string, name string, spaceGUID string) *resource.ServiceInstance {
+	return &resource.ServiceInstance{
+		Name: name,
+		Resource: resource.Resource{
+			GUID: guid,
+		},
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: spaceGUID,
+				},
+			},
+		},
+	}
+}
+
+func newFixtureMetadata(labels map[string]string, annotations map[string]string) *resource.Metadata {
+	metadata := &resource.Metadata{
+		Labels:      make(map[string]*string, len(labels)),
+		Annotations: make(map[string]*string, len(annotations)),
+	}
+	for key, value := range labels {
+		value := value
+		metadata.Labels[key] = &value
+	}
+	for key, value := range annotations {
+		value := value
+		metadata.Annotations[key] = &value
+	}
+	return metadata
+}
@@ -0,0 +1,228 @@
+package brokertags
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testFixture = `
+organizations:
+  - guid: org-guid-1
+    name: org-1
+    labels:
+      cost-center: abc
+spaces:
+  - guid: space-guid-1
+    name: space-1
+    organization_guid: org-guid-1
+service_instances:
+  - guid: instance-guid-1
+    name: instance-1
+    space_guid: space-guid-1
+`
+
+func TestParseResourceFixture(t *testing.T) {
+	testCases := map[string]struct {
+		data            string
+		expectedFixture *ResourceFixture
+		expectedErr     error
+	}{
+		"yaml": {
+			data: testFixture,
+			expectedFixture: &ResourceFixture{
+				Organizations: []FixtureOrganization{
+					{GUID: "org-guid-1", Name: "org-1", Labels: map[string]string{"cost-center": "abc"}},
+				},
+				Spaces: []FixtureSpace{
+					{GUID: "space-guid-1", Name: "space-1", OrganizationGUID: "org-guid-1"},
+				},
+				ServiceInstances: []FixtureServiceInstance{
+					{GUID: "instance-guid-1", Name: "instance-1", SpaceGUID: "space-guid-1"},
+				},
+			},
+		},
+		"json": {
+			data: `{"spaces": [{"guid": "space-guid-1", "name": "space-1"}]}`,
+			expectedFixture: &ResourceFixture{
+				Spaces: []FixtureSpace{
+					{GUID: "space-guid-1", Name: "space-1"},
+				},
+			},
+		},
+		"empty": {
+			expectedFixture: &ResourceFixture{},
+		},
+		"unknown field": {
+			data:        `{"spaces": [{"guid": "space-guid-1", "nmae": "space-1"}]}`,
+			expectedErr: errors.New("error parsing resource fixture: yaml: unmarshal errors:\n  line 1: field nmae not found in type brokertags.FixtureSpace"),
+		},
+		"missing GUID": {
+			data:        `{"organizations": [{"name": "org-1"}]}`,
+			expectedErr: errors.New("resource fixture has a organization without a GUID"),
+		},
+		"duplicate GUID": {
+			data:        `{"service_instances": [{"guid": "instance-guid-1"}, {"guid": "instance-guid-1"}]}`,
+			expectedErr: errors.New("resource fixture has duplicate service instance GUID: instance-guid-1"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fixture, err := ParseResourceFixture([]byte(test.data))
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cmp.Equal(fixture, test.expectedFixture) {
+				t.Errorf(cmp.Diff(fixture, test.expectedFixture))
+			}
+		})
+	}
+}
+
+func TestLoadResourceFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.yml")
+	if err := os.WriteFile(path, []byte(testFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := LoadResourceFixture(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(fixture.ServiceInstances) != 1 {
+		t.Errorf("expected 1 service instance, got: %d", len(fixture.ServiceInstances))
+	}
+
+	_, err = LoadResourceFixture(filepath.Join(t.TempDir(), "missing.yml"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
+
+func TestOfflineTagManager(t *testing.T) {
+	fixture, err := ParseResourceFixture([]byte(testFixture))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	clock := ClockFunc(func() time.Time {
+		return time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	})
+
+	testCases := map[string]struct {
+		fixture       *ResourceFixture
+		opts          []Option
+		resourceGUIDs ResourceGUIDs
+		expectedTags  map[string]string
+	}{
+		"resources in fixture": {
+			fixture:       fixture,
+			resourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"},
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"environment":           "testing",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Instance GUID":         "instance-guid-1",
+				"Instance name":         "instance-1",
+				"Space GUID":            "space-guid-1",
+				"Space name":            "space-1",
+				"Organization GUID":     "org-guid-1",
+				"Organization name":     "org-1",
+				"Updated at":            "2024-01-02T03:04:05Z",
+			},
+		},
+		"metadata from fixture": {
+			fixture:       fixture,
+			opts:          []Option{WithMetadataTags(MetadataTagConfig{Keys: []string{"cost-center"}})},
+			resourceGUIDs: ResourceGUIDs{SpaceGUID: "space-guid-1"},
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"environment":           "testing",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Space GUID":            "space-guid-1",
+				"Space name":            "space-1",
+				"Organization GUID":     "org-guid-1",
+				"Organization name":     "org-1",
+				"Updated at":            "2024-01-02T03:04:05Z",
+				"cost-center":           "abc",
+			},
+		},
+		"resources not in fixture": {
+			fixture: fixture,
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID:     "instance-guid-2",
+				SpaceGUID:        "space-guid-2",
+				OrganizationGUID: "org-guid-2",
+			},
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"environment":           "testing",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Instance GUID":         "instance-guid-2",
+				"Instance name":         "instance-guid-2",
+				"Space GUID":            "space-guid-2",
+				"Space name":            "space-guid-2",
+				"Organization GUID":     "org-guid-2",
+				"Organization name":     "org-guid-2",
+				"Updated at":            "2024-01-02T03:04:05Z",
+			},
+		},
+		"no fixture": {
+			resourceGUIDs: ResourceGUIDs{InstanceGUID: "instance-guid-1"},
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"environment":           "testing",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Instance GUID":         "instance-guid-1",
+				"Instance name":         "instance-guid-1",
+				"Updated at":            "2024-01-02T03:04:05Z",
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager, err := NewOfflineTagManager(
+				"AWS Broker",
+				"Testing",
+				test.fixture,
+				append(test.opts, WithClock(clock))...,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			tags, err := tagManager.GenerateTags(Update, "abc1", "abc2", test.resourceGUIDs, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+		})
+	}
+}
+
+func TestOfflineTagManagerQuotaTags(t *testing.T) {
+	_, err := NewOfflineTagManager("", "", nil, WithQuotaTags(QuotaTagConfig{OrganizationQuota: true}))
+	expectedErr := "quota tags are enabled, but the ResourceGetter is not a QuotaGetter"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+	}
+}
@@ -0,0 +1,246 @@
+package brokertags
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/config"
+)
+
+// Option configures optional behavior of a CfTagManager created by
+// NewCFTagManager.
+type Option func(*options)
+
+type options struct {
+	resourceGetter  ResourceGetter
+	cfClient        *client.Client
+	cfConfig        *config.Config
+	cfConfigOptions []config.Option
+
+	httpClient        *http.Client
+	skipTLSValidation bool
+	retryPolicy       RetryPolicy
+
+	lookupTimeout        time.Duration
+	maxConcurrentLookups int
+	cacheTTL             time.Duration
+	cacheMaxSize         int
+	userTagLimits        UserTagLimits
+	metadataTags         *MetadataTagConfig
+	quotaTags            QuotaTagConfig
+
+	clock             Clock
+	timestampLayout   string
+	timestampLocation *time.Location
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		retryPolicy: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithResourceGetter makes the CfTagManager look up resources with
+// resourceGetter instead of the CF API. No CF API client is created.
+func WithResourceGetter(resourceGetter ResourceGetter) Option {
+	return func(o *options) {
+		o.resourceGetter = resourceGetter
+	}
+}
+
+// WithCFClient makes the CfTagManager use an existing CF API client, instead
+// of creating its own.
+func WithCFClient(cf *client.Client) Option {
+	return func(o *options) {
+		o.cfClient = cf
+	}
+}
+
+// WithConfig makes the CfTagManager create its CF API client from an existing
+// go-cfclient configuration, instead of from the CF API URL and client
+// credentials.
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.cfConfig = cfg
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests to the CF API and UAA,
+// for instance to use a custom transport or CA bundle. It has no effect when
+// combined with WithCFClient or WithConfig.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithSkipTLSValidation disables TLS certificate validation for requests to
+// the CF API and UAA. It has no effect when combined with WithCFClient or
+// WithConfig. When combined with WithHTTPClient, the client's transport must
+// be nil or an *http.Transport, or NewCFTagManager returns an error.
+func WithSkipTLSValidation() Option {
+	return func(o *options) {
+		o.skipTLSValidation = true
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests to the CF API. It has
+// no effect when combined with WithCFClient or WithConfig.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		o.cfConfigOptions = append(o.cfConfigOptions, config.UserAgent(userAgent))
+	}
+}
+
+// WithRetryPolicy sets how CF API lookups that fail with a transient error
+// are retried. By default, DefaultRetryPolicy is used; a policy with
+// MaxAttempts of one disables retries. The Retry-After header of 429 and 503
+// responses is only honored when the CfTagManager creates its own CF API
+// client, that is, when neither WithCFClient nor WithConfig is used.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithLookupTimeout bounds how long each individual CF API lookup made while
+// generating tags may take. A zero or negative timeout means lookups are only
+// bounded by the context passed to GenerateTagsContext.
+func WithLookupTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lookupTimeout = timeout
+	}
+}
+
+// WithMaxConcurrentLookups bounds how many CF API lookups a single call to
+// GenerateTags may make at once. Lookups of resources whose GUIDs are known
+// up front run concurrently, up to this limit; a limit of one makes them
+// sequential. By default, up to three lookups run at once.
+func WithMaxConcurrentLookups(limit int) Option {
+	return func(o *options) {
+		o.maxConcurrentLookups = limit
+	}
+}
+
+// WithCache caches organization, space and service instance lookups for the
+// given TTL, including lookups of resources that were not found. When maxSize
+// is greater than zero, at most maxSize lookups are kept and the least
+// recently used are evicted first. A zero or negative TTL disables caching.
+func WithCache(ttl time.Duration, maxSize int) Option {
+	return func(o *options) {
+		o.cacheTTL = ttl
+		o.cacheMaxSize = maxSize
+	}
+}
+
+// WithUserTagLimits sets the limits that user tags passed to GenerateTags with
+// WithUserTags must stay within. Any limit left at zero uses the corresponding
+// limit from DefaultUserTagLimits.
+func WithUserTagLimits(limits UserTagLimits) Option {
+	return func(o *options) {
+		o.userTagLimits = limits
+	}
+}
+
+// WithMetadataTags copies the CF metadata labels and annotations selected by
+// config from the organization, space and service instance into the generated
+// tags.
+func WithMetadataTags(config MetadataTagConfig) Option {
+	return func(o *options) {
+		o.metadataTags = &config
+	}
+}
+
+// WithQuotaTags adds the optional organization quota, space quota and
+// isolation segment tags selected by config, which are all off by default.
+// Each enabled tag costs up to one more CF API request per generation, or up
+// to three for the isolation segment, which may be assigned to the space or
+// be the default of the organization. The lookups are cached by WithCache,
+// and GenerateTagsBatch makes them once per batch.
+func WithQuotaTags(config QuotaTagConfig) Option {
+	return func(o *options) {
+		o.quotaTags = config
+	}
+}
+
+// WithClock sets the clock used for the action timestamp tags, such as
+// "Created at". By default, the system clock is used.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithTimestampLayout sets the layout, as understood by time.Time.Format, of
+// the action timestamp tags. For example, time.DateOnly gives date-only
+// timestamps. By default, timestamps are formatted as time.RFC3339.
+func WithTimestampLayout(layout string) Option {
+	return func(o *options) {
+		o.timestampLayout = layout
+	}
+}
+
+// WithTimestampLocation sets the location, such as time.UTC, that the action
+// timestamp tags are given in. By default, the local time is used.
+func WithTimestampLocation(location *time.Location) Option {
+	return func(o *options) {
+		o.timestampLocation = location
+	}
+}
+
+// GenerateOption configures a single call to GenerateTags or
+// GenerateTagsContext.
+type GenerateOption func(*generateOptions)
+
+type generateOptions struct {
+	userTags            map[string]string
+	bestEffort          bool
+	originatingIdentity *OriginatingIdentity
+}
+
+func newGenerateOptions(opts []GenerateOption) *generateOptions {
+	o := &generateOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithUserTags adds tags supplied by the user, for instance in the parameters
+// of a provision request, to the generated tags. The user tags are validated
+// with the ValidateUserTags method of the CfTagManager first, which also
+// reserves the keys of metadata tags; if they are invalid, no tags are
+// generated and a *UserTagsValidationError is returned.
+func WithUserTags(tags map[string]string) GenerateOption {
+	return func(o *generateOptions) {
+		o.userTags = tags
+	}
+}
+
+// WithBestEffort makes GenerateTags return the tags it can still generate when
+// looking up a resource fails, instead of no tags at all. The tags are then
+// returned together with a *PartialTagsError listing the failed lookups, which
+// can be logged so that the resource can be tagged again later. Passing the
+// tags to DiffTags or MergeTags keeps the existing tags of the resources that
+// could not be looked up.
+func WithBestEffort() GenerateOption {
+	return func(o *generateOptions) {
+		o.bestEffort = true
+	}
+}
+
+// WithOriginatingIdentity adds a tag recording the user who did the action,
+// such as "Created by" for Create or "Updated by" for Update, with the user ID
+// of identity as its value. Use ParseOriginatingIdentity to get the identity
+// from the X-Broker-API-Originating-Identity header of the OSB request. A nil
+// identity adds no tag.
+func WithOriginatingIdentity(identity *OriginatingIdentity) GenerateOption {
+	return func(o *generateOptions) {
+		o.originatingIdentity = identity
+	}
+}
@@ -0,0 +1,189 @@
+package brokertags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// The platforms of OSB context objects.
+const (
+	CloudFoundryPlatform = "cloudfoundry"
+	KubernetesPlatform   = "kubernetes"
+)
+
+// OSBContext holds the fields of the OSB context object, sent with provision,
+// update and bind requests since OSB API 2.15, that are used to generate
+// tags. Which fields are set depends on the platform.
+type OSBContext struct {
+	Platform     string `json:"platform"`
+	InstanceName string `json:"instance_name"`
+
+	// Cloud Foundry
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+
+	// Kubernetes
+	Namespace string `json:"namespace"`
+	ClusterID string `json:"clusterid"`
+}
+
+// ParseOSBContext parses the raw JSON of an OSB context object. An empty
+// context gives an empty OSBContext.
+func ParseOSBContext(rawContext json.RawMessage) (*OSBContext, error) {
+	osbContext := &OSBContext{}
+	if len(rawContext) == 0 {
+		return osbContext, nil
+	}
+	if err := json.Unmarshal(rawContext, osbContext); err != nil {
+		return nil, fmt.Errorf("error parsing OSB context: %w", err)
+	}
+	return osbContext, nil
+}
+
+// GenerateTagsFromOSBContext generates tags like GenerateTagsContext, taking
+// the space and organization GUIDs and the instance, space and organization
+// names from the raw OSB context object of the request. Only the names that
+// are missing from the context are looked up with the ResourceGetter, so in
+// the common case no CF API requests are made.
+//
+// When metadata tags are configured with WithMetadataTags, or quota tags with
+// WithQuotaTags, every resource is still looked up, since the context does not
+// have their labels or quotas.
+func (t *CfTagManager) GenerateTagsFromOSBContext(
+	ctx context.Context,
+	action Action,
+	serviceName string,
+	planName string,
+	instanceGUID string,
+	rawContext json.RawMessage,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	osbContext, err := ParseOSBContext(rawContext)
+	if err != nil {
+		return nil, err
+	}
+	if osbContext.Platform != "" && osbContext.Platform != CloudFoundryPlatform {
+		return nil, fmt.Errorf("unsupported OSB context platform: %s", osbContext.Platform)
+	}
+
+	osbTagManager := *t
+	if !t.needsFullSpaceAndOrganization() {
+		osbTagManager.cfResourceGetter = withIncludesOf(&osbContextResourceGetter{
+			osbContext:   osbContext,
+			instanceGUID: instanceGUID,
+			next:         t.cfResourceGetter,
+		}, t.cfResourceGetter)
+	}
+	return osbTagManager.GenerateTagsContext(
+		ctx,
+		action,
+		serviceName,
+		planName,
+		ResourceGUIDs{
+			InstanceGUID:     instanceGUID,
+			SpaceGUID:        osbContext.SpaceGUID,
+			OrganizationGUID: osbContext.OrganizationGUID,
+		},
+		true,
+		opts...,
+	)
+}
+
+// osbContextResourceGetter is a ResourceGetter that serves the resources
+// described by an OSB context object, and passes lookups of any other
+// resources, or of resources whose names are missing from the context, to
+// another ResourceGetter.
+type osbContextResourceGetter struct {
+	osbContext   *OSBContext
+	instanceGUID string
+	next         ResourceGetter
+}
+
+func (o *osbContextResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	if organization := o.organization(organizationGUID); organization != nil {
+		return organization, nil
+	}
+	return o.next.GetOrganization(ctx, organizationGUID)
+}
+
+func (o *osbContextResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	if space := o.space(spaceGUID); space != nil {
+		return space, nil
+	}
+	return o.next.GetSpace(ctx, spaceGUID)
+}
+
+func (o *osbContextResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	if instance := o.serviceInstance(instanceGUID); instance != nil {
+		return instance, nil
+	}
+	return o.next.GetServiceInstance(ctx, instanceGUID)
+}
+
+func (o *osbContextResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	instance := o.serviceInstance(instanceGUID)
+	if instance == nil {
+		return o.next.(ServiceInstanceIncludeGetter).GetServiceInstanceIncludeSpaceAndOrganization(ctx, instanceGUID)
+	}
+	return instance, o.space(o.osbContext.SpaceGUID), o.organization(o.osbContext.OrganizationGUID), nil
+}
+
+func (o *osbContextResourceGetter) organization(organizationGUID string) *resource.Organization {
+	if organizationGUID == "" || organizationGUID != o.osbContext.OrganizationGUID || o.osbContext.OrganizationName == "" {
+		return nil
+	}
+	return &resource.Organization{
+		Name: o.osbContext.OrganizationName,
+		Resource: resource.Resource{
+			GUID: organizationGUID,
+		},
+	}
+}
+
+func (o *osbContextResourceGetter) space(spaceGUID string) *resource.Space {
+	// Without the organization GUID, the space is looked up to find out which
+	// organization it is in.
+	if spaceGUID == "" || spaceGUID != o.osbContext.SpaceGUID || o.osbContext.SpaceName == "" || o.osbContext.OrganizationGUID == "" {
+		return nil
+	}
+	return &resource.Space{
+		Name: o.osbContext.SpaceName,
+		Resource: resource.Resource{
+			GUID: spaceGUID,
+		},
+		Relationships: &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: o.osbContext.OrganizationGUID,
+				},
+			},
+		},
+	}
+}
+
+func (o *osbContextResourceGetter) serviceInstance(instanceGUID string) *resource.ServiceInstance {
+	if instanceGUID == "" || instanceGUID != o.instanceGUID || o.osbContext.InstanceName == "" || o.osbContext.SpaceGUID == "" {
+		return nil
+	}
+	return &resource.ServiceInstance{
+		Name: o.osbContext.InstanceName,
+		Resource: resource.Resource{
+			GUID: instanceGUID,
+		},
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: o.osbContext.SpaceGUID,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,237 @@
+package brokertags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOSBContext(t *testing.T) {
+	testCases := map[string]struct {
+		rawContext      json.RawMessage
+		expectedContext *OSBContext
+		expectedErr     bool
+	}{
+		"full context": {
+			rawContext: json.RawMessage(`{
+				"platform": "cloudfoundry",
+				"organization_guid": "org-guid-1",
+				"organization_name": "org-1",
+				"space_guid": "space-guid-1",
+				"space_name": "space-1",
+				"instance_name": "instance-1",
+				"organization_annotations": {"example.com/team": "a"}
+			}`),
+			expectedContext: &OSBContext{
+				Platform:         "cloudfoundry",
+				OrganizationGUID: "org-guid-1",
+				OrganizationName: "org-1",
+				SpaceGUID:        "space-guid-1",
+				SpaceName:        "space-1",
+				InstanceName:     "instance-1",
+			},
+		},
+		"empty context": {
+			expectedContext: &OSBContext{},
+		},
+		"invalid JSON": {
+			rawContext:  json.RawMessage(`{"space_guid":`),
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			osbContext, err := ParseOSBContext(test.rawContext)
+			if test.expectedErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(osbContext, test.expectedContext) {
+				t.Errorf(cmp.Diff(osbContext, test.expectedContext))
+			}
+		})
+	}
+}
+
+func TestGenerateTagsFromOSBContext(t *testing.T) {
+	testCases := map[string]struct {
+		rawContext                   json.RawMessage
+		metadataTags                 *MetadataTagConfig
+		quotaTags                    QuotaTagConfig
+		expectedTags                 map[string]string
+		expectedErr                  error
+		expectedGetInstanceCallCount int
+		expectedGetSpaceCallCount    int
+		expectedGetOrgCallCount      int
+		expectedGetIncludesCallCount int
+	}{
+		"names from context": {
+			rawContext: json.RawMessage(`{
+				"platform": "cloudfoundry",
+				"organization_guid": "org-guid-1",
+				"organization_name": "context-org",
+				"space_guid": "space-guid-1",
+				"space_name": "context-space",
+				"instance_name": "context-instance"
+			}`),
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "context-instance",
+				SpaceGUIDTagKey:           "space-guid-1",
+				SpaceNameTagKey:           "context-space",
+				OrganizationGUIDTagKey:    "org-guid-1",
+				OrganizationNameTagKey:    "context-org",
+			},
+		},
+		"missing names are looked up": {
+			rawContext: json.RawMessage(`{
+				"platform": "cloudfoundry",
+				"organization_guid": "org-guid-1",
+				"space_guid": "space-guid-1",
+				"space_name": "context-space"
+			}`),
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "instance-1",
+				SpaceGUIDTagKey:           "space-guid-1",
+				SpaceNameTagKey:           "context-space",
+				OrganizationGUIDTagKey:    "org-guid-1",
+				OrganizationNameTagKey:    "org-1",
+			},
+			expectedGetInstanceCallCount: 1,
+			expectedGetOrgCallCount:      1,
+		},
+		"no context": {
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "instance-1",
+				SpaceGUIDTagKey:           "space-guid-1",
+				SpaceNameTagKey:           "space-1",
+				OrganizationGUIDTagKey:    "org-guid-1",
+				OrganizationNameTagKey:    "org-1",
+			},
+			expectedGetIncludesCallCount: 1,
+		},
+		"metadata tags": {
+			rawContext: json.RawMessage(`{
+				"organization_guid": "org-guid-1",
+				"organization_name": "context-org",
+				"space_guid": "space-guid-1",
+				"space_name": "context-space",
+				"instance_name": "context-instance"
+			}`),
+			metadataTags: &MetadataTagConfig{},
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "instance-1",
+				SpaceGUIDTagKey:           "space-guid-1",
+				SpaceNameTagKey:           "space-1",
+				OrganizationGUIDTagKey:    "org-guid-1",
+				OrganizationNameTagKey:    "org-1",
+			},
+			expectedGetInstanceCallCount: 1,
+			expectedGetSpaceCallCount:    1,
+			expectedGetOrgCallCount:      1,
+		},
+		"quota tags": {
+			rawContext: json.RawMessage(`{
+				"organization_guid": "org-guid-1",
+				"organization_name": "context-org",
+				"space_guid": "space-guid-1",
+				"space_name": "context-space",
+				"instance_name": "context-instance"
+			}`),
+			quotaTags: QuotaTagConfig{SpaceQuota: true},
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "instance-1",
+				SpaceGUIDTagKey:           "space-guid-1",
+				SpaceNameTagKey:           "space-1",
+				OrganizationGUIDTagKey:    "org-guid-1",
+				OrganizationNameTagKey:    "org-1",
+			},
+			expectedGetInstanceCallCount: 1,
+			expectedGetSpaceCallCount:    1,
+			expectedGetOrgCallCount:      1,
+		},
+		"other platform": {
+			rawContext:  json.RawMessage(`{"platform": "kubernetes"}`),
+			expectedErr: errors.New("unsupported OSB context platform: kubernetes"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfResourceGetter := &mockCFClientWrapper{
+				instanceName:     "instance-1",
+				spaceName:        "space-1",
+				organizationName: "org-1",
+				instanceGUID:     "instance-guid-1",
+				spaceGUID:        "space-guid-1",
+				organizationGUID: "org-guid-1",
+			}
+			tagManager := &CfTagManager{
+				broker:           "AWS Broker",
+				cfResourceGetter: cfResourceGetter,
+				metadataTags:     test.metadataTags,
+				quotaTags:        test.quotaTags,
+			}
+			tags, err := tagManager.GenerateTagsFromOSBContext(
+				context.Background(),
+				Update,
+				"abc1",
+				"abc2",
+				"instance-guid-1",
+				test.rawContext,
+			)
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			delete(tags, "Updated at")
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+			if cfResourceGetter.getServiceInstanceCallCount != test.expectedGetInstanceCallCount {
+				t.Errorf("expected %d service instance lookups, got: %d", test.expectedGetInstanceCallCount, cfResourceGetter.getServiceInstanceCallCount)
+			}
+			if cfResourceGetter.getSpaceInstanceCallCount != test.expectedGetSpaceCallCount {
+				t.Errorf("expected %d space lookups, got: %d", test.expectedGetSpaceCallCount, cfResourceGetter.getSpaceInstanceCallCount)
+			}
+			if cfResourceGetter.getOrganizationCallCount != test.expectedGetOrgCallCount {
+				t.Errorf("expected %d organization lookups, got: %d", test.expectedGetOrgCallCount, cfResourceGetter.getOrganizationCallCount)
+			}
+			if cfResourceGetter.getIncludesCallCount != test.expectedGetIncludesCallCount {
+				t.Errorf("expected %d includes lookups, got: %d", test.expectedGetIncludesCallCount, cfResourceGetter.getIncludesCallCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package brokertags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The values of the client tag for each platform.
+const (
+	cloudFoundryClient = "Cloud Foundry"
+	kubernetesClient   = "Kubernetes"
+)
+
+var (
+	_ OSBContextTagManager = (*CfTagManager)(nil)
+	_ OSBContextTagManager = (*KubernetesTagManager)(nil)
+	_ OSBContextTagManager = (*PlatformTagManager)(nil)
+
+	_ TagManager = (*PlatformTagManager)(nil)
+)
+
+// OSBContextTagManager generates tags from the OSB context object of a
+// request, such as a CfTagManager for Cloud Foundry or a KubernetesTagManager
+// for Kubernetes.
+type OSBContextTagManager interface {
+	GenerateTagsFromOSBContext(
+		ctx context.Context,
+		action Action,
+		serviceName string,
+		planName string,
+		instanceGUID string,
+		rawContext json.RawMessage,
+		opts ...GenerateOption,
+	) (map[string]string, error)
+}
+
+// KubernetesTagManager generates tags for service instances created through a
+// Kubernetes service catalog. Instead of organization and space tags, it adds
+// the namespace and cluster ID from the OSB context object. It is not a
+// TagManager, since there are no Cloud Foundry GUIDs to look up names by.
+type KubernetesTagManager struct {
+	settings tagSettings
+}
+
+// NewKubernetesTagManager creates a KubernetesTagManager. Only the options
+// that do not concern CF API lookups apply, such as WithClock or
+// WithUserTagLimits.
+func NewKubernetesTagManager(broker string, environment string, opts ...Option) *KubernetesTagManager {
+	return &KubernetesTagManager{
+		settings: newTagSettings(broker, environment, newOptions(opts)),
+	}
+}
+
+// GenerateTagsFromOSBContext generates tags from the Kubernetes OSB context
+// object of a request. It makes no requests, so ctx is unused.
+func (k *KubernetesTagManager) GenerateTagsFromOSBContext(
+	ctx context.Context,
+	action Action,
+	serviceName string,
+	planName string,
+	instanceGUID string,
+	rawContext json.RawMessage,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	osbContext, err := ParseOSBContext(rawContext)
+	if err != nil {
+		return nil, err
+	}
+	if osbContext.Platform != KubernetesPlatform {
+		return nil, fmt.Errorf("unsupported OSB context platform: %s", osbContext.Platform)
+	}
+
+	o := newGenerateOptions(opts)
+	tags, err := k.settings.baseTags(kubernetesClient, action, serviceName, planName, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if instanceGUID != "" {
+		tags[ServiceInstanceGUIDTagKey] = instanceGUID
+	}
+
+	if osbContext.InstanceName != "" && action.instanceExists() {
+		tags[ServiceInstanceNameTagKey] = osbContext.InstanceName
+	}
+
+	if osbContext.Namespace != "" {
+		tags[NamespaceTagKey] = osbContext.Namespace
+	}
+
+	if osbContext.ClusterID != "" {
+		tags[ClusterIDTagKey] = osbContext.ClusterID
+	}
+
+	for key, value := range o.userTags {
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// PlatformTagManager generates tags from the OSB context object of a request
+// with the OSBContextTagManager for the platform in the context. Contexts
+// without a platform are taken to be from Cloud Foundry.
+//
+// It is also a TagManager that generates tags from resource GUIDs with its
+// Cloud Foundry manager, so that brokers holding a TagManager can switch to
+// it.
+type PlatformTagManager struct {
+	cloudFoundry *CfTagManager
+	managers     map[string]OSBContextTagManager
+}
+
+// NewPlatformTagManager creates a PlatformTagManager that uses cloudFoundry
+// and kubernetes for requests from those platforms. Either may be nil if the
+// broker is not registered with that platform.
+func NewPlatformTagManager(cloudFoundry *CfTagManager, kubernetes *KubernetesTagManager) *PlatformTagManager {
+	p := &PlatformTagManager{
+		managers: make(map[string]OSBContextTagManager),
+	}
+	if cloudFoundry != nil {
+		p.cloudFoundry = cloudFoundry
+		p.managers[CloudFoundryPlatform] = cloudFoundry
+	}
+	if kubernetes != nil {
+		p.managers[KubernetesPlatform] = kubernetes
+	}
+	return p
+}
+
+func (p *PlatformTagManager) GenerateTagsFromOSBContext(
+	ctx context.Context,
+	action Action,
+	serviceName string,
+	planName string,
+	instanceGUID string,
+	rawContext json.RawMessage,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	osbContext, err := ParseOSBContext(rawContext)
+	if err != nil {
+		return nil, err
+	}
+	platform := osbContext.Platform
+	if platform == "" {
+		platform = CloudFoundryPlatform
+	}
+	manager, ok := p.managers[platform]
+	if !ok {
+		return nil, fmt.Errorf("unsupported OSB context platform: %s", platform)
+	}
+	return manager.GenerateTagsFromOSBContext(ctx, action, serviceName, planName, instanceGUID, rawContext, opts...)
+}
+
+// GenerateTags generates tags from resource GUIDs with the Cloud Foundry
+// manager. It returns an error if there is none.
+func (p *PlatformTagManager) GenerateTags(
+	action Action,
+	serviceName string,
+	servicePlanName string,
+	resourceGUIDs ResourceGUIDs,
+	getMissingResources bool,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	return p.GenerateTagsContext(
+		context.Background(),
+		action,
+		serviceName,
+		servicePlanName,
+		resourceGUIDs,
+		getMissingResources,
+		opts...,
+	)
+}
+
+// GenerateTagsContext is like GenerateTags, but uses ctx for the lookups.
+func (p *PlatformTagManager) GenerateTagsContext(
+	ctx context.Context,
+	action Action,
+	serviceName string,
+	servicePlanName string,
+	resourceGUIDs ResourceGUIDs,
+	getMissingResources bool,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	if p.cloudFoundry == nil {
+		return nil, errors.New("cannot generate tags from resource GUIDs without a Cloud Foundry tag manager")
+	}
+	return p.cloudFoundry.GenerateTagsContext(
+		ctx,
+		action,
+		serviceName,
+		servicePlanName,
+		resourceGUIDs,
+		getMissingResources,
+		opts...,
+	)
+}
@@ -0,0 +1,206 @@
+package brokertags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKubernetesTagManager(t *testing.T) {
+	clock := ClockFunc(func() time.Time {
+		return time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	})
+	tagManager := NewKubernetesTagManager("AWS Broker", "Testing", WithClock(clock))
+
+	testCases := map[string]struct {
+		action       Action
+		rawContext   json.RawMessage
+		opts         []GenerateOption
+		expectedTags map[string]string
+		expectedErr  error
+	}{
+		"update": {
+			action: Update,
+			rawContext: json.RawMessage(`{
+				"platform": "kubernetes",
+				"namespace": "team-a",
+				"clusterid": "cluster-1",
+				"instance_name": "db-1"
+			}`),
+			opts: []GenerateOption{
+				WithOriginatingIdentity(&OriginatingIdentity{Platform: "kubernetes", UserID: "duke"}),
+				WithUserTags(map[string]string{"Cost center": "abc"}),
+			},
+			expectedTags: map[string]string{
+				ClientTagKey:              "Kubernetes",
+				BrokerTagKey:              "AWS Broker",
+				EnvironmentTagKey:         "testing",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				ServiceInstanceNameTagKey: "db-1",
+				NamespaceTagKey:           "team-a",
+				ClusterIDTagKey:           "cluster-1",
+				"Updated at":              "2024-01-02T03:04:05Z",
+				"Updated by":              "duke",
+				"Cost center":             "abc",
+			},
+		},
+		"create": {
+			action:     Create,
+			rawContext: json.RawMessage(`{"platform": "kubernetes", "namespace": "team-a", "instance_name": "db-1"}`),
+			expectedTags: map[string]string{
+				ClientTagKey:              "Kubernetes",
+				BrokerTagKey:              "AWS Broker",
+				EnvironmentTagKey:         "testing",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+				NamespaceTagKey:           "team-a",
+				"Created at":              "2024-01-02T03:04:05Z",
+			},
+		},
+		"cloud foundry context": {
+			action:      Create,
+			rawContext:  json.RawMessage(`{"platform": "cloudfoundry"}`),
+			expectedErr: errors.New("unsupported OSB context platform: cloudfoundry"),
+		},
+		"reserved user tag": {
+			action:      Create,
+			rawContext:  json.RawMessage(`{"platform": "kubernetes"}`),
+			opts:        []GenerateOption{WithUserTags(map[string]string{"namespace": "other"})},
+			expectedErr: errors.New(`invalid tags: "namespace": key is reserved`),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags, err := tagManager.GenerateTagsFromOSBContext(
+				context.Background(),
+				test.action,
+				"abc1",
+				"abc2",
+				"instance-guid-1",
+				test.rawContext,
+				test.opts...,
+			)
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+		})
+	}
+}
+
+func TestPlatformTagManager(t *testing.T) {
+	cfTagManager := &CfTagManager{
+		cfResourceGetter: &mockCFClientWrapper{
+			spaceName:        "space-1",
+			organizationName: "org-1",
+		},
+	}
+	kubernetesTagManager := NewKubernetesTagManager("", "")
+
+	testCases := map[string]struct {
+		tagManager     *PlatformTagManager
+		rawContext     json.RawMessage
+		expectedClient string
+		expectedErr    error
+	}{
+		"cloud foundry": {
+			tagManager:     NewPlatformTagManager(cfTagManager, kubernetesTagManager),
+			rawContext:     json.RawMessage(`{"platform": "cloudfoundry", "space_guid": "space-guid-1"}`),
+			expectedClient: "Cloud Foundry",
+		},
+		"no platform": {
+			tagManager:     NewPlatformTagManager(cfTagManager, kubernetesTagManager),
+			rawContext:     json.RawMessage(`{"space_guid": "space-guid-1"}`),
+			expectedClient: "Cloud Foundry",
+		},
+		"kubernetes": {
+			tagManager:     NewPlatformTagManager(cfTagManager, kubernetesTagManager),
+			rawContext:     json.RawMessage(`{"platform": "kubernetes", "namespace": "team-a"}`),
+			expectedClient: "Kubernetes",
+		},
+		"no kubernetes tag manager": {
+			tagManager:  NewPlatformTagManager(cfTagManager, nil),
+			rawContext:  json.RawMessage(`{"platform": "kubernetes", "namespace": "team-a"}`),
+			expectedErr: errors.New("unsupported OSB context platform: kubernetes"),
+		},
+		"unknown platform": {
+			tagManager:  NewPlatformTagManager(cfTagManager, kubernetesTagManager),
+			rawContext:  json.RawMessage(`{"platform": "nomad"}`),
+			expectedErr: errors.New("unsupported OSB context platform: nomad"),
+		},
+		"invalid context": {
+			tagManager:  NewPlatformTagManager(cfTagManager, kubernetesTagManager),
+			rawContext:  json.RawMessage(`[]`),
+			expectedErr: errors.New("error parsing OSB context: json: cannot unmarshal array into Go value of type brokertags.OSBContext"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags, err := test.tagManager.GenerateTagsFromOSBContext(
+				context.Background(),
+				Create,
+				"abc1",
+				"abc2",
+				"instance-guid-1",
+				test.rawContext,
+			)
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tags[ClientTagKey] != test.expectedClient {
+				t.Errorf("expected client: %s, got: %s", test.expectedClient, tags[ClientTagKey])
+			}
+		})
+	}
+}
+
+func TestPlatformTagManagerGenerateTags(t *testing.T) {
+	cfTagManager := &CfTagManager{
+		cfResourceGetter: &mockCFClientWrapper{
+			spaceName:        "space-1",
+			spaceGUID:        "space-guid-1",
+			organizationName: "org-1",
+			organizationGUID: "org-guid-1",
+		},
+	}
+	kubernetesTagManager := NewKubernetesTagManager("", "")
+
+	var tagManager TagManager = NewPlatformTagManager(cfTagManager, kubernetesTagManager)
+	tags, err := tagManager.GenerateTags(Create, "abc1", "abc2", ResourceGUIDs{SpaceGUID: "space-guid-1"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tags[SpaceNameTagKey] != "space-1" {
+		t.Errorf("expected space name: space-1, got: %s", tags[SpaceNameTagKey])
+	}
+
+	tagManager = NewPlatformTagManager(nil, kubernetesTagManager)
+	_, err = tagManager.GenerateTags(Create, "abc1", "abc2", ResourceGUIDs{SpaceGUID: "space-guid-1"}, false)
+	expectedErr := "cannot generate tags from resource GUIDs without a Cloud Foundry tag manager"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+	}
+}
@@ -0,0 +1,158 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+const (
+	OrganizationQuotaTagKey = "Organization quota name"
+	SpaceQuotaTagKey        = "Space quota name"
+	IsolationSegmentTagKey  = "Isolation segment name"
+)
+
+// QuotaTagConfig selects which of the optional organization quota, space quota
+// and isolation segment tags are generated. Each of them needs an additional
+// lookup.
+type QuotaTagConfig struct {
+	// OrganizationQuota adds the name of the organization's quota.
+	OrganizationQuota bool
+	// SpaceQuota adds the name of the space's quota, if it has one.
+	SpaceQuota bool
+	// IsolationSegment adds the name of the isolation segment that the space's
+	// apps run in, if it is not the shared one.
+	IsolationSegment bool
+}
+
+func (c QuotaTagConfig) enabled() bool {
+	return c.OrganizationQuota || c.SpaceQuota || c.IsolationSegment
+}
+
+// QuotaGetter looks up the quotas and isolation segments of organizations and
+// spaces, for the tags enabled with WithQuotaTags. The CF API ResourceGetter is
+// a QuotaGetter; a ResourceGetter provided with WithResourceGetter must be one
+// too for those tags to be enabled.
+type QuotaGetter interface {
+	GetOrganizationQuota(ctx context.Context, organizationQuotaGUID string) (*resource.OrganizationQuota, error)
+	GetSpaceQuota(ctx context.Context, spaceQuotaGUID string) (*resource.SpaceQuota, error)
+	// GetSpaceIsolationSegment returns the isolation segment assigned to the
+	// space, or else the default isolation segment of its organization, or nil
+	// if there is neither.
+	GetSpaceIsolationSegment(ctx context.Context, spaceGUID string, organizationGUID string) (*resource.IsolationSegment, error)
+}
+
+// checkQuotaGetter returns an error if quota tags are enabled but
+// resourceGetter cannot look up quotas.
+func checkQuotaGetter(resourceGetter ResourceGetter, o *options) error {
+	if _, ok := resourceGetter.(QuotaGetter); o.quotaTags.enabled() && !ok {
+		return errors.New("quota tags are enabled, but the ResourceGetter is not a QuotaGetter")
+	}
+	return nil
+}
+
+// needsFullSpaceAndOrganization reports whether the configured tags need
+// fields of the space and organization, such as their metadata or quotas,
+// that are missing when they are included with the service instance or taken
+// from an OSB context object.
+func (t *CfTagManager) needsFullSpaceAndOrganization() bool {
+	return t.metadataTags != nil || t.quotaTags.OrganizationQuota || t.quotaTags.SpaceQuota
+}
+
+// addQuotaTags looks up the quotas and isolation segment enabled with
+// WithQuotaTags concurrently, and adds their names to tags. Failed lookups
+// are returned as errors unless skipLookup says otherwise.
+func (t *CfTagManager) addQuotaTags(
+	ctx context.Context,
+	tags map[string]string,
+	space *resource.Space,
+	spaceGUID string,
+	organization *resource.Organization,
+	organizationGUID string,
+	skipLookup func(error) bool,
+) error {
+	var (
+		organizationQuota    *resource.OrganizationQuota
+		organizationQuotaErr error
+		spaceQuota           *resource.SpaceQuota
+		spaceQuotaErr        error
+		isolationSegment     *resource.IsolationSegment
+		isolationSegmentErr  error
+		lookups              = newLookupGroup(t.maxConcurrentLookups)
+	)
+	if t.quotaTags.OrganizationQuota && organization != nil && organization.Relationships.Quota.Data != nil {
+		quotaGUID := organization.Relationships.Quota.Data.GUID
+		lookups.Go(func() {
+			organizationQuota, organizationQuotaErr = t.getOrganizationQuota(ctx, quotaGUID)
+		})
+	}
+	if t.quotaTags.SpaceQuota && space != nil && space.Relationships != nil &&
+		space.Relationships.Quota != nil && space.Relationships.Quota.Data != nil {
+		quotaGUID := space.Relationships.Quota.Data.GUID
+		lookups.Go(func() {
+			spaceQuota, spaceQuotaErr = t.getSpaceQuota(ctx, quotaGUID)
+		})
+	}
+	if t.quotaTags.IsolationSegment && spaceGUID != "" {
+		lookups.Go(func() {
+			isolationSegment, isolationSegmentErr = t.getSpaceIsolationSegment(ctx, spaceGUID, organizationGUID)
+		})
+	}
+	lookups.Wait()
+
+	var failed []error
+	for _, err := range []error{organizationQuotaErr, spaceQuotaErr, isolationSegmentErr} {
+		if err != nil && !skipLookup(err) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return joinErrors(failed)
+	}
+
+	if organizationQuota != nil {
+		tags[OrganizationQuotaTagKey] = organizationQuota.Name
+	}
+	if spaceQuota != nil {
+		tags[SpaceQuotaTagKey] = spaceQuota.Name
+	}
+	if isolationSegment != nil {
+		tags[IsolationSegmentTagKey] = isolationSegment.Name
+	}
+	return nil
+}
+
+func (t *CfTagManager) getOrganizationQuota(ctx context.Context, quotaGUID string) (*resource.OrganizationQuota, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	quota, err := t.quotaGetter.GetOrganizationQuota(ctx, quotaGUID)
+	if err != nil {
+		return nil, newLookupError(OrganizationQuotaResource, quotaGUID, err)
+	}
+	return quota, nil
+}
+
+func (t *CfTagManager) getSpaceQuota(ctx context.Context, quotaGUID string) (*resource.SpaceQuota, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	quota, err := t.quotaGetter.GetSpaceQuota(ctx, quotaGUID)
+	if err != nil {
+		return nil, newLookupError(SpaceQuotaResource, quotaGUID, err)
+	}
+	return quota, nil
+}
+
+func (t *CfTagManager) getSpaceIsolationSegment(
+	ctx context.Context,
+	spaceGUID string,
+	organizationGUID string,
+) (*resource.IsolationSegment, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	isolationSegment, err := t.quotaGetter.GetSpaceIsolationSegment(ctx, spaceGUID, organizationGUID)
+	if err != nil {
+		return nil, newLookupError(IsolationSegmentResource, spaceGUID, err)
+	}
+	return isolationSegment, nil
+}
@@ -0,0 +1,288 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// mockQuotaGetter is a ResourceGetter and QuotaGetter for one organization
+// with a quota and one space, which has a quota if spaceQuotaName is set.
+type mockQuotaGetter struct {
+	mockCFClientWrapper
+	organizationQuotaName   string
+	spaceQuotaName          string
+	isolationSegmentName    string
+	getQuotaErr             error
+	getIsolationSegmentErr  error
+	isolationSegmentLookups []string
+}
+
+func (m *mockQuotaGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	organization, err := m.mockCFClientWrapper.GetOrganization(ctx, organizationGUID)
+	if err != nil {
+		return nil, err
+	}
+	organization.Relationships.Quota.Data = &resource.Relationship{GUID: "org-quota-guid-1"}
+	return organization, nil
+}
+
+func (m *mockQuotaGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	space, err := m.mockCFClientWrapper.GetSpace(ctx, spaceGUID)
+	if err != nil {
+		return nil, err
+	}
+	if m.spaceQuotaName != "" {
+		space.Relationships.Quota = &resource.ToOneRelationship{
+			Data: &resource.Relationship{GUID: "space-quota-guid-1"},
+		}
+	}
+	return space, nil
+}
+
+func (m *mockQuotaGetter) GetOrganizationQuota(ctx context.Context, organizationQuotaGUID string) (*resource.OrganizationQuota, error) {
+	if m.getQuotaErr != nil {
+		return nil, m.getQuotaErr
+	}
+	if organizationQuotaGUID != "org-quota-guid-1" {
+		return nil, errors.New("organization quota GUID does not match expected value")
+	}
+	return &resource.OrganizationQuota{Name: m.organizationQuotaName}, nil
+}
+
+func (m *mockQuotaGetter) GetSpaceQuota(ctx context.Context, spaceQuotaGUID string) (*resource.SpaceQuota, error) {
+	if m.getQuotaErr != nil {
+		return nil, m.getQuotaErr
+	}
+	if spaceQuotaGUID != "space-quota-guid-1" {
+		return nil, errors.New("space quota GUID does not match expected value")
+	}
+	return &resource.SpaceQuota{Name: m.spaceQuotaName}, nil
+}
+
+func (m *mockQuotaGetter) GetSpaceIsolationSegment(
+	ctx context.Context,
+	spaceGUID string,
+	organizationGUID string,
+) (*resource.IsolationSegment, error) {
+	m.isolationSegmentLookups = append(m.isolationSegmentLookups, spaceGUID+" "+organizationGUID)
+	if m.getIsolationSegmentErr != nil {
+		return nil, m.getIsolationSegmentErr
+	}
+	if m.isolationSegmentName == "" {
+		return nil, nil
+	}
+	return &resource.IsolationSegment{Name: m.isolationSegmentName}, nil
+}
+
+func TestGenerateTagsQuotaTags(t *testing.T) {
+	allQuotaTags := QuotaTagConfig{OrganizationQuota: true, SpaceQuota: true, IsolationSegment: true}
+
+	testCases := map[string]struct {
+		quotaGetter                     *mockQuotaGetter
+		quotaTags                       QuotaTagConfig
+		opts                            []GenerateOption
+		expectedTags                    map[string]string
+		expectedErr                     error
+		expectedIsolationSegmentLookups []string
+	}{
+		"all quota tags": {
+			quotaGetter: &mockQuotaGetter{
+				organizationQuotaName: "sandbox",
+				spaceQuotaName:        "small",
+				isolationSegmentName:  "prod",
+			},
+			quotaTags: allQuotaTags,
+			expectedTags: map[string]string{
+				OrganizationQuotaTagKey: "sandbox",
+				SpaceQuotaTagKey:        "small",
+				IsolationSegmentTagKey:  "prod",
+			},
+			expectedIsolationSegmentLookups: []string{"space-guid-1 org-guid-1"},
+		},
+		"no space quota or isolation segment": {
+			quotaGetter: &mockQuotaGetter{
+				organizationQuotaName: "sandbox",
+			},
+			quotaTags: allQuotaTags,
+			expectedTags: map[string]string{
+				OrganizationQuotaTagKey: "sandbox",
+			},
+			expectedIsolationSegmentLookups: []string{"space-guid-1 org-guid-1"},
+		},
+		"only organization quota": {
+			quotaGetter: &mockQuotaGetter{
+				organizationQuotaName: "sandbox",
+				spaceQuotaName:        "small",
+				isolationSegmentName:  "prod",
+			},
+			quotaTags: QuotaTagConfig{OrganizationQuota: true},
+			expectedTags: map[string]string{
+				OrganizationQuotaTagKey: "sandbox",
+			},
+		},
+		"disabled": {
+			quotaGetter: &mockQuotaGetter{
+				organizationQuotaName: "sandbox",
+				spaceQuotaName:        "small",
+				isolationSegmentName:  "prod",
+			},
+			expectedTags: map[string]string{},
+		},
+		"quota lookup fails": {
+			quotaGetter: &mockQuotaGetter{
+				getQuotaErr:            errors.New("quota failed"),
+				getIsolationSegmentErr: errors.New("isolation segment failed"),
+			},
+			quotaTags: allQuotaTags,
+			expectedErr: errors.New("error getting organization quota org-quota-guid-1: quota failed\n" +
+				"error getting isolation segment space-guid-1: isolation segment failed"),
+			expectedIsolationSegmentLookups: []string{"space-guid-1 org-guid-1"},
+		},
+		"best effort": {
+			quotaGetter: &mockQuotaGetter{
+				spaceQuotaName:       "small",
+				isolationSegmentName: "prod",
+				getQuotaErr:          errors.New("quota failed"),
+			},
+			quotaTags: allQuotaTags,
+			opts:      []GenerateOption{WithBestEffort()},
+			expectedTags: map[string]string{
+				IsolationSegmentTagKey: "prod",
+			},
+			expectedErr: errors.New("incomplete tags: error getting organization quota org-quota-guid-1: quota failed; " +
+				"error getting space quota space-quota-guid-1: quota failed"),
+			expectedIsolationSegmentLookups: []string{"space-guid-1 org-guid-1"},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test.quotaGetter.mockCFClientWrapper = mockCFClientWrapper{
+				instanceName:     "instance-1",
+				instanceGUID:     "instance-guid-1",
+				spaceName:        "space-1",
+				spaceGUID:        "space-guid-1",
+				organizationName: "org-1",
+				organizationGUID: "org-guid-1",
+			}
+			tagManager, err := NewCFTagManager(
+				"",
+				"",
+				"",
+				"",
+				"",
+				WithResourceGetter(test.quotaGetter),
+				WithQuotaTags(test.quotaTags),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			tags, err := tagManager.GenerateTags(
+				Create,
+				"",
+				"",
+				ResourceGUIDs{InstanceGUID: "instance-guid-1"},
+				true,
+				test.opts...,
+			)
+			if test.expectedErr != nil {
+				if err == nil || err.Error() != test.expectedErr.Error() {
+					t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.expectedTags != nil {
+				quotaTags := make(map[string]string)
+				for _, key := range []string{OrganizationQuotaTagKey, SpaceQuotaTagKey, IsolationSegmentTagKey} {
+					if value, ok := tags[key]; ok {
+						quotaTags[key] = value
+					}
+				}
+				if !cmp.Equal(quotaTags, test.expectedTags) {
+					t.Errorf(cmp.Diff(quotaTags, test.expectedTags))
+				}
+			}
+			if !cmp.Equal(test.quotaGetter.isolationSegmentLookups, test.expectedIsolationSegmentLookups) {
+				t.Errorf(cmp.Diff(test.quotaGetter.isolationSegmentLookups, test.expectedIsolationSegmentLookups))
+			}
+			if test.quotaTags.OrganizationQuota && test.quotaGetter.getIncludesCallCount != 0 {
+				t.Errorf("expected the space and organization to be looked up without includes")
+			}
+		})
+	}
+}
+
+func TestNewCFTagManagerRequiresQuotaGetter(t *testing.T) {
+	_, err := NewCFTagManager(
+		"",
+		"",
+		"",
+		"",
+		"",
+		WithResourceGetter(&mockCFClientWrapper{}),
+		WithQuotaTags(QuotaTagConfig{IsolationSegment: true}),
+	)
+	expectedErr := "quota tags are enabled, but the ResourceGetter is not a QuotaGetter"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+	}
+
+	_, err = NewCFTagManager("", "", "", "", "", WithResourceGetter(&mockCFClientWrapper{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestGenerateTagsQuotaTagsWithCache(t *testing.T) {
+	quotaGetter := &mockQuotaGetter{
+		mockCFClientWrapper: mockCFClientWrapper{
+			instanceName:     "instance-1",
+			instanceGUID:     "instance-guid-1",
+			spaceName:        "space-1",
+			spaceGUID:        "space-guid-1",
+			organizationName: "org-1",
+			organizationGUID: "org-guid-1",
+		},
+		organizationQuotaName: "sandbox",
+		isolationSegmentName:  "prod",
+	}
+	tagManager, err := NewCFTagManager(
+		"",
+		"",
+		"",
+		"",
+		"",
+		WithResourceGetter(quotaGetter),
+		WithQuotaTags(QuotaTagConfig{OrganizationQuota: true, IsolationSegment: true}),
+		WithCache(time.Minute, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tags, err := tagManager.GenerateTags(Create, "", "", ResourceGUIDs{InstanceGUID: "instance-guid-1"}, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if tags[OrganizationQuotaTagKey] != "sandbox" || tags[IsolationSegmentTagKey] != "prod" {
+			t.Errorf("unexpected tags: %v", tags)
+		}
+	}
+
+	expectedLookups := []string{"space-guid-1 org-guid-1"}
+	if !cmp.Equal(quotaGetter.isolationSegmentLookups, expectedLookups) {
+		t.Errorf(cmp.Diff(quotaGetter.isolationSegmentLookups, expectedLookups))
+	}
+	expectedStats := CacheStats{Hits: 10, Misses: 5}
+	if tagManager.CacheStats() != expectedStats {
+		t.Errorf("expected cache stats: %+v, got: %+v", expectedStats, tagManager.CacheStats())
+	}
+}
@@ -0,0 +1,157 @@
+package brokertags
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how lookups against the CF API are retried when they
+// fail with a transient error, such as a 5xx or 429 response or a connection
+// error. Lookups that fail for any other reason, such as a 404 or 401
+// response, are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a lookup is tried, including the first
+	// attempt. A value of one or less disables retries.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps how long to wait before any retry. A lookup whose
+	// response asks, with a Retry-After header, for a longer wait is not
+	// retried.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the wait grows after each retry.
+	Multiplier float64
+	// Jitter randomizes each wait by up to this fraction of it, in either
+	// direction, so that many clients do not retry at the same moment.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy used unless another is set with
+// WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// backoff returns how long to wait after the given failed attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// sleepFunc waits for d, or until ctx is done.
+type sleepFunc func(ctx context.Context, d time.Duration) error
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retry calls lookup until it succeeds, fails with an error that is not
+// transient, or the policy's attempts run out. If a failed attempt's response
+// had a Retry-After header, it is waited for instead of the policy's backoff,
+// unless it is longer than the policy's MaxBackoff, in which case the lookup
+// is not retried at all.
+func retry[T any](
+	ctx context.Context,
+	policy RetryPolicy,
+	sleep sleepFunc,
+	lookup func(context.Context) (T, error),
+) (T, error) {
+	for attempt := 1; ; attempt++ {
+		hint := &retryAfterHint{}
+		value, err := lookup(context.WithValue(ctx, retryAfterHintKey{}, hint))
+		if err == nil || attempt >= policy.MaxAttempts || classifyError(err) != ErrTransient {
+			return value, err
+		}
+
+		wait := policy.backoff(attempt)
+		if hint.ok {
+			if policy.MaxBackoff > 0 && hint.wait > policy.MaxBackoff {
+				return value, err
+			}
+			wait = hint.wait
+		}
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			return value, err
+		}
+	}
+}
+
+type retryAfterHintKey struct{}
+
+// retryAfterHint records the Retry-After header of a response to a lookup,
+// which go-cfclient does not include in its errors.
+type retryAfterHint struct {
+	wait time.Duration
+	ok   bool
+}
+
+// retryAfterTransport is an http.RoundTripper that records the Retry-After
+// header of 429 and 503 responses in the retryAfterHint of the request's
+// context, if it has one.
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	hint, ok := req.Context().Value(retryAfterHintKey{}).(*retryAfterHint)
+	if !ok || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return resp, nil
+	}
+	if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		hint.wait = wait
+		hint.ok = true
+	}
+	return resp, nil
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := date.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
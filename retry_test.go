@@ -0,0 +1,272 @@
+package brokertags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     150 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	testCases := map[string]struct {
+		policy           *RetryPolicy
+		responses        []func(w http.ResponseWriter)
+		expectedAttempts int
+		expectedSleeps   []time.Duration
+		expectedErr      error
+	}{
+		"success": {
+			responses:        []func(w http.ResponseWriter){respondWithSpace},
+			expectedAttempts: 1,
+		},
+		"retries server errors": {
+			responses: []func(w http.ResponseWriter){
+				respondWithStatus(http.StatusServiceUnavailable),
+				respondWithStatus(http.StatusBadGateway),
+				respondWithSpace,
+			},
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{100 * time.Millisecond, 150 * time.Millisecond},
+		},
+		"retries connection errors": {
+			responses: []func(w http.ResponseWriter){
+				closeConnection,
+				respondWithSpace,
+			},
+			expectedAttempts: 2,
+			expectedSleeps:   []time.Duration{100 * time.Millisecond},
+		},
+		"honors Retry-After": {
+			policy: &RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     5 * time.Second,
+				Multiplier:     2,
+			},
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "2")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+				respondWithSpace,
+			},
+			expectedAttempts: 2,
+			expectedSleeps:   []time.Duration{2 * time.Second},
+		},
+		"does not wait past max backoff": {
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+				respondWithSpace,
+			},
+			expectedAttempts: 1,
+			expectedErr:      ErrTransient,
+		},
+		"gives up after max attempts": {
+			responses: []func(w http.ResponseWriter){
+				respondWithStatus(http.StatusInternalServerError),
+				respondWithStatus(http.StatusInternalServerError),
+				respondWithStatus(http.StatusInternalServerError),
+				respondWithSpace,
+			},
+			expectedAttempts: 3,
+			expectedSleeps:   []time.Duration{100 * time.Millisecond, 150 * time.Millisecond},
+			expectedErr:      ErrTransient,
+		},
+		"does not retry not found": {
+			responses: []func(w http.ResponseWriter){
+				respondWithStatus(http.StatusNotFound),
+				respondWithSpace,
+			},
+			expectedAttempts: 1,
+			expectedErr:      ErrNotFound,
+		},
+		"does not retry unauthorized": {
+			responses: []func(w http.ResponseWriter){
+				respondWithStatus(http.StatusUnauthorized),
+				respondWithStatus(http.StatusUnauthorized),
+				respondWithSpace,
+			},
+			// go-cfclient itself retries once with a new token.
+			expectedAttempts: 2,
+			expectedErr:      ErrUnauthorized,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				attempts int
+			)
+			server := newFakeCFAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				respond := test.responses[attempts]
+				attempts++
+				mu.Unlock()
+				respond(w)
+			})
+
+			testPolicy := policy
+			if test.policy != nil {
+				testPolicy = *test.policy
+			}
+			o := newOptions([]Option{
+				WithRetryPolicy(testPolicy),
+				// Without keep-alives, the transport does not retry requests
+				// on closed connections by itself.
+				WithHTTPClient(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}),
+			})
+			getter, err := newCFResourceGetter(server.URL, "client-id", "client-secret", o)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var sleeps []time.Duration
+			getter.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			space, err := getter.GetSpace(context.Background(), "space-guid-1")
+			if test.expectedErr != nil {
+				if classifyError(err) != test.expectedErr {
+					t.Errorf("expected %s error, got: %v", test.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if space.Name != "space-1" {
+				t.Errorf("expected space name: space-1, got: %s", space.Name)
+			}
+			if attempts != test.expectedAttempts {
+				t.Errorf("expected %d attempts, got: %d", test.expectedAttempts, attempts)
+			}
+			if !cmp.Equal(sleeps, test.expectedSleeps) {
+				t.Errorf(cmp.Diff(sleeps, test.expectedSleeps))
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	attempts := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := retry(ctx, DefaultRetryPolicy, sleep, func(ctx context.Context) (string, error) {
+		attempts++
+		cancel()
+		return "", ErrTransient
+	})
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("expected transient error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got: %d", attempts)
+	}
+}
+
+func TestRetryDoesNotRetryUnknownHost(t *testing.T) {
+	attempts := 0
+	_, err := retry(context.Background(), DefaultRetryPolicy, sleep, func(ctx context.Context) (*http.Response, error) {
+		attempts++
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://broker-tags-test.invalid/", nil)
+		if err != nil {
+			return nil, err
+		}
+		return http.DefaultClient.Do(req)
+	})
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Skipf("DNS is not available: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got: %d", attempts)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		backoff := policy.backoff(attempt + 1)
+		if backoff < expected/2 || backoff > expected*3/2 {
+			t.Errorf("expected backoff of attempt %d within 50%% of %s, got: %s", attempt+1, expected, backoff)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := map[string]struct {
+		value        string
+		expectedWait time.Duration
+		expectedOK   bool
+	}{
+		"seconds": {
+			value:        "30",
+			expectedWait: 30 * time.Second,
+			expectedOK:   true,
+		},
+		"HTTP date": {
+			value:        now.Add(time.Minute).Format(http.TimeFormat),
+			expectedWait: time.Minute,
+			expectedOK:   true,
+		},
+		"HTTP date in the past": {
+			value:      now.Add(-time.Minute).Format(http.TimeFormat),
+			expectedOK: true,
+		},
+		"empty": {},
+		"negative": {
+			value: "-1",
+		},
+		"invalid": {
+			value: "soon",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			wait, ok := parseRetryAfter(test.value, now)
+			if wait != test.expectedWait || ok != test.expectedOK {
+				t.Errorf("expected (%s, %t), got: (%s, %t)", test.expectedWait, test.expectedOK, wait, ok)
+			}
+		})
+	}
+}
+
+func respondWithSpace(w http.ResponseWriter) {
+	fmt.Fprint(w, `{"guid": "space-guid-1", "name": "space-1"}`)
+}
+
+func respondWithStatus(statusCode int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(statusCode)
+	}
+}
+
+func closeConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	conn.Close()
+}
@@ -1,6 +1,8 @@
 package brokertags
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"time"
 
@@ -19,8 +21,43 @@ const (
 	ServicePlanName           = "Service plan name"
 	SpaceGUIDTagKey           = "Space GUID"
 	SpaceNameTagKey           = "Space name"
+	NamespaceTagKey           = "Namespace"
+	ClusterIDTagKey           = "Cluster ID"
 )
 
+// managedTagKeys are the keys of the tags that this package generates. Tags
+// with any other key are never changed or removed by DiffTags and MergeTags.
+var managedTagKeys = map[string]bool{
+	BrokerTagKey:              true,
+	ClientTagKey:              true,
+	EnvironmentTagKey:         true,
+	OrganizationGUIDTagKey:    true,
+	OrganizationNameTagKey:    true,
+	ServiceInstanceGUIDTagKey: true,
+	ServiceInstanceNameTagKey: true,
+	ServiceNameTagKey:         true,
+	ServicePlanName:           true,
+	SpaceGUIDTagKey:           true,
+	SpaceNameTagKey:           true,
+	NamespaceTagKey:           true,
+	ClusterIDTagKey:           true,
+	OrganizationQuotaTagKey:   true,
+	SpaceQuotaTagKey:          true,
+	IsolationSegmentTagKey:    true,
+	createdAtTagKey:           true,
+	updatedAtTagKey:           true,
+	boundAtTagKey:             true,
+	unboundAtTagKey:           true,
+	deletedAtTagKey:           true,
+	restoredAtTagKey:          true,
+	createdByTagKey:           true,
+	updatedByTagKey:           true,
+	boundByTagKey:             true,
+	unboundByTagKey:           true,
+	deletedByTagKey:           true,
+	restoredByTagKey:          true,
+}
+
 type TagManager interface {
 	GenerateTags(
 		action Action,
@@ -28,6 +65,16 @@ type TagManager interface {
 		servicePlanName string,
 		resourceGUIDs ResourceGUIDs,
 		getMissingResources bool,
+		opts ...GenerateOption,
+	) (map[string]string, error)
+	GenerateTagsContext(
+		ctx context.Context,
+		action Action,
+		serviceName string,
+		servicePlanName string,
+		resourceGUIDs ResourceGUIDs,
+		getMissingResources bool,
+		opts ...GenerateOption,
 	) (map[string]string, error)
 }
 
@@ -35,28 +82,115 @@ type CfTagManager struct {
 	broker           string
 	environment      string
 	cfResourceGetter ResourceGetter
+	lookupTimeout    time.Duration
+	cache            *cachingResourceGetter
+	userTagLimits    UserTagLimits
+	metadataTags     *MetadataTagConfig
+	quotaTags        QuotaTagConfig
+	quotaGetter      QuotaGetter
+
+	clock             Clock
+	timestampLayout   string
+	timestampLocation *time.Location
+
+	maxConcurrentLookups int
 }
 
+// NewCFTagManager creates a CfTagManager that looks up resource names with
+// the CF API at cfApiUrl, authenticating with the given client credentials.
+//
+// Options can change how the CF API client is created. WithCFClient and
+// WithConfig use an existing client or configuration instead, and
+// WithResourceGetter replaces the CF API lookups altogether; in those cases
+// cfApiUrl, cfApiClientId and cfApiClientSecret are ignored.
 func NewCFTagManager(
 	broker string,
 	environment string,
 	cfApiUrl string,
 	cfApiClientId string,
 	cfApiClientSecret string,
+	opts ...Option,
 ) (*CfTagManager, error) {
+	o := newOptions(opts)
+	if o.resourceGetter != nil {
+		if err := checkQuotaGetter(o.resourceGetter, o); err != nil {
+			return nil, err
+		}
+		return newCfTagManager(broker, environment, o.resourceGetter, o), nil
+	}
 	cfResourceGetter, err := newCFResourceGetter(
 		cfApiUrl,
 		cfApiClientId,
 		cfApiClientSecret,
+		o,
 	)
 	if err != nil {
 		return nil, err
 	}
-	return &CfTagManager{
-		broker,
-		environment,
-		cfResourceGetter,
-	}, nil
+	return newCfTagManager(broker, environment, cfResourceGetter, o), nil
+}
+
+func newCfTagManager(
+	broker string,
+	environment string,
+	resourceGetter ResourceGetter,
+	o *options,
+) *CfTagManager {
+	t := &CfTagManager{
+		broker:           broker,
+		environment:      environment,
+		cfResourceGetter: resourceGetter,
+		lookupTimeout:    o.lookupTimeout,
+		userTagLimits:    o.userTagLimits,
+		metadataTags:     o.metadataTags,
+		quotaTags:        o.quotaTags,
+
+		clock:             o.clock,
+		timestampLayout:   o.timestampLayout,
+		timestampLocation: o.timestampLocation,
+
+		maxConcurrentLookups: o.maxConcurrentLookups,
+	}
+	if quotaGetter, ok := resourceGetter.(QuotaGetter); ok && o.quotaTags.enabled() {
+		t.quotaGetter = quotaGetter
+	}
+	if o.cacheTTL > 0 {
+		t.cache = newCachingResourceGetter(resourceGetter, o.cacheTTL, o.cacheMaxSize)
+		t.cfResourceGetter = withIncludesOf(t.cache, resourceGetter)
+		if t.quotaGetter != nil {
+			t.quotaGetter = t.cache
+		}
+	}
+	return t
+}
+
+// CacheStats returns the hit and miss counts of the lookup cache enabled with
+// WithCache. It returns zero counts if caching is not enabled.
+func (t *CfTagManager) CacheStats() CacheStats {
+	if t.cache == nil {
+		return CacheStats{}
+	}
+	return t.cache.Stats()
+}
+
+// DedupStats returns how many CF API lookups were made and how many were
+// merged with an identical lookup already in flight. It returns zero counts
+// if the CfTagManager does not look up resources with the CF API.
+func (t *CfTagManager) DedupStats() DedupStats {
+	cfResourceGetter, ok := t.uncachedResourceGetter().(*cfResourceGetter)
+	if !ok || cfResourceGetter.flights == nil {
+		return DedupStats{}
+	}
+	return cfResourceGetter.flights.Stats()
+}
+
+// uncachedResourceGetter returns the ResourceGetter of the CfTagManager
+// without the cache enabled with WithCache, if any.
+func (t *CfTagManager) uncachedResourceGetter() ResourceGetter {
+	if t.cache != nil {
+		return t.cache.next
+	}
+	return t.cfResourceGetter
 }
 
 type ResourceGUIDs struct {
@@ -71,27 +205,50 @@ func (t *CfTagManager) GenerateTags(
 	planName string,
 	resourceGUIDs ResourceGUIDs,
 	getMissingResources bool,
+	opts ...GenerateOption,
 ) (map[string]string, error) {
-	tags := make(map[string]string)
-
-	tags[ClientTagKey] = "Cloud Foundry"
-
-	tags[action.getTagKey()] = time.Now().Format(time.RFC3339)
-
-	if t.broker != "" {
-		tags[BrokerTagKey] = t.broker
-	}
+	return t.GenerateTagsContext(
+		context.Background(),
+		action,
+		serviceName,
+		planName,
+		resourceGUIDs,
+		getMissingResources,
+		opts...,
+	)
+}
 
-	if t.environment != "" {
-		tags[EnvironmentTagKey] = strings.ToLower(t.environment)
-	}
+// GenerateTagsContext is like GenerateTags, but any CF API lookups are made
+// with the provided context, so they stop when it is canceled or its deadline
+// passes. If a lookup timeout was configured, each lookup is additionally
+// bounded by that timeout.
+func (t *CfTagManager) GenerateTagsContext(
+	ctx context.Context,
+	action Action,
+	serviceName string,
+	planName string,
+	resourceGUIDs ResourceGUIDs,
+	getMissingResources bool,
+	opts ...GenerateOption,
+) (map[string]string, error) {
+	o := newGenerateOptions(opts)
 
-	if serviceName != "" {
-		tags[ServiceNameTagKey] = serviceName
+	tags, err := t.settings().baseTags(cloudFoundryClient, action, serviceName, planName, o)
+	if err != nil {
+		return nil, err
 	}
 
-	if planName != "" {
-		tags[ServicePlanName] = planName
+	var warnings []*LookupError
+	// skipLookup reports whether tags can still be generated after a failed
+	// lookup, which is only the case in best-effort mode. The lookup error is
+	// then kept as a warning.
+	skipLookup := func(err error) bool {
+		var lookupErr *LookupError
+		if !o.bestEffort || !errors.As(err, &lookupErr) {
+			return false
+		}
+		warnings = append(warnings, lookupErr)
+		return true
 	}
 
 	var (
@@ -101,7 +258,6 @@ func (t *CfTagManager) GenerateTags(
 		space            *resource.Space
 		organizationGUID string
 		organization     *resource.Organization
-		err              error
 	)
 
 	instanceGUID = resourceGUIDs.InstanceGUID
@@ -109,31 +265,71 @@ func (t *CfTagManager) GenerateTags(
 		tags[ServiceInstanceGUIDTagKey] = instanceGUID
 	}
 
-	if instanceGUID != "" && action == Update {
-		instance, err = t.cfResourceGetter.getServiceInstance(instanceGUID)
-		if err != nil {
-			return nil, err
-		}
+	spaceGUID = resourceGUIDs.SpaceGUID
+	organizationGUID = resourceGUIDs.OrganizationGUID
+
+	// First, look up concurrently every resource whose GUID is already known.
+	var (
+		includedOrganization *resource.Organization
+		instanceErr          error
+		spaceErr             error
+		organizationErr      error
+		lookups              = newLookupGroup(t.maxConcurrentLookups)
+	)
+	_, canInclude := t.cfResourceGetter.(ServiceInstanceIncludeGetter)
+	includeSpaceAndOrganization := canInclude && spaceGUID == "" && instanceGUID != "" && !t.needsFullSpaceAndOrganization()
+	if includeSpaceAndOrganization {
+		// Resolve the instance together with its space and organization in a
+		// single request, rather than one request for each resource. The
+		// included space and organization do not have any metadata or quotas,
+		// so this is only done when no metadata or quota tags are configured.
+		lookups.Go(func() {
+			instance, space, includedOrganization, instanceErr = t.getServiceInstanceIncludeSpaceAndOrganization(ctx, instanceGUID)
+		})
+	} else if instanceGUID != "" && (action.instanceExists() || spaceGUID == "" || t.metadataTags != nil) {
+		lookups.Go(func() {
+			instance, instanceErr = t.getServiceInstance(ctx, instanceGUID)
+		})
 	}
+	if spaceGUID != "" {
+		lookups.Go(func() {
+			space, spaceErr = t.getSpace(ctx, spaceGUID)
+		})
+	}
+	if organizationGUID != "" && !includeSpaceAndOrganization {
+		lookups.Go(func() {
+			organization, organizationErr = t.getOrganization(ctx, organizationGUID)
+		})
+	}
+	lookups.Wait()
 
-	if instance != nil {
-		tags[ServiceInstanceNameTagKey] = instance.Name
+	var failed []error
+	for _, err := range []error{instanceErr, spaceErr, organizationErr} {
+		if err != nil && !skipLookup(err) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return nil, joinErrors(failed)
 	}
 
-	spaceGUID = resourceGUIDs.SpaceGUID
+	// Then, look up the resources whose GUIDs had to be derived from another
+	// resource.
 	if spaceGUID == "" && instance != nil {
 		spaceGUID = instance.Relationships.Space.Data.GUID
-	} else if spaceGUID == "" && instanceGUID != "" {
-		spaceGUID, err = t.getSpaceGuid(resourceGUIDs.InstanceGUID)
-		if err != nil {
-			return nil, err
-		}
+	}
+
+	if instance != nil && action.instanceExists() {
+		tags[ServiceInstanceNameTagKey] = instance.Name
 	}
 
 	if spaceGUID != "" {
 		tags[SpaceGUIDTagKey] = spaceGUID
-		space, err = t.cfResourceGetter.getSpace(spaceGUID)
-		if err != nil {
+	}
+
+	if spaceGUID != "" && space == nil && spaceErr == nil {
+		space, err = t.getSpace(ctx, spaceGUID)
+		if err != nil && !skipLookup(err) {
 			return nil, err
 		}
 	}
@@ -142,15 +338,21 @@ func (t *CfTagManager) GenerateTags(
 		tags[SpaceNameTagKey] = space.Name
 	}
 
-	organizationGUID = resourceGUIDs.OrganizationGUID
 	if organizationGUID == "" && getMissingResources {
 		organizationGUID = t.getOrganizationGuidFromSpace(space)
 	}
 
+	if includedOrganization != nil && includedOrganization.GUID == organizationGUID {
+		organization = includedOrganization
+	}
+
 	if organizationGUID != "" {
 		tags[OrganizationGUIDTagKey] = organizationGUID
-		organization, err = t.cfResourceGetter.getOrganization(organizationGUID)
-		if err != nil {
+	}
+
+	if organizationGUID != "" && organization == nil && organizationErr == nil {
+		organization, err = t.getOrganization(ctx, organizationGUID)
+		if err != nil && !skipLookup(err) {
 			return nil, err
 		}
 	}
@@ -159,16 +361,25 @@ func (t *CfTagManager) GenerateTags(
 		tags[OrganizationNameTagKey] = organization.Name
 	}
 
-	return tags, nil
-}
+	if t.quotaTags.enabled() && t.quotaGetter != nil {
+		err := t.addQuotaTags(ctx, tags, space, spaceGUID, organization, organizationGUID, skipLookup)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func (t *CfTagManager) getSpaceGuid(instanceGUID string) (string, error) {
-	instance, err := t.cfResourceGetter.getServiceInstance(instanceGUID)
-	if err != nil {
-		return "", err
+	if t.metadataTags != nil {
+		t.metadataTags.addTags(tags, organization, space, instance)
+	}
+
+	for key, value := range o.userTags {
+		tags[key] = value
+	}
+
+	if len(warnings) > 0 {
+		return tags, &PartialTagsError{Warnings: warnings}
 	}
-	spaceGUID := instance.Relationships.Space.Data.GUID
-	return spaceGUID, nil
+	return tags, nil
 }
 
 func (t *CfTagManager) getOrganizationGuidFromSpace(space *resource.Space) string {
@@ -177,3 +388,161 @@ func (t *CfTagManager) getOrganizationGuidFromSpace(space *resource.Space) strin
 	}
 	return space.Relationships.Organization.Data.GUID
 }
+
+func (t *CfTagManager) settings() tagSettings {
+	return tagSettings{
+		broker:            t.broker,
+		environment:       t.environment,
+		userTagLimits:     t.userTagLimits,
+		metadataTags:      t.metadataTags,
+		clock:             t.clock,
+		timestampLayout:   t.timestampLayout,
+		timestampLocation: t.timestampLocation,
+	}
+}
+
+// tagSettings are the settings of a TagManager that apply on every platform.
+type tagSettings struct {
+	broker            string
+	environment       string
+	userTagLimits     UserTagLimits
+	metadataTags      *MetadataTagConfig
+	clock             Clock
+	timestampLayout   string
+	timestampLocation *time.Location
+}
+
+func newTagSettings(broker string, environment string, o *options) tagSettings {
+	return tagSettings{
+		broker:            broker,
+		environment:       environment,
+		userTagLimits:     o.userTagLimits,
+		clock:             o.clock,
+		timestampLayout:   o.timestampLayout,
+		timestampLocation: o.timestampLocation,
+	}
+}
+
+// baseTags validates the user tags, then returns the tags that do not depend
+// on any of the platform's resources, with client as the client tag. The user
+// tags themselves are left for the caller to add last.
+func (s tagSettings) baseTags(
+	client string,
+	action Action,
+	serviceName string,
+	planName string,
+	o *generateOptions,
+) (map[string]string, error) {
+	if err := validateUserTags(o.userTags, s.userTagLimits, s.metadataTags); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+
+	tags[ClientTagKey] = client
+
+	actionTagKey, err := action.getTagKey()
+	if err != nil {
+		return nil, err
+	}
+	tags[actionTagKey] = s.timestamp()
+
+	if o.originatingIdentity != nil {
+		identityTagKey, err := action.getIdentityTagKey()
+		if err != nil {
+			return nil, err
+		}
+		tags[identityTagKey] = o.originatingIdentity.UserID
+	}
+
+	if s.broker != "" {
+		tags[BrokerTagKey] = s.broker
+	}
+
+	if s.environment != "" {
+		tags[EnvironmentTagKey] = strings.ToLower(s.environment)
+	}
+
+	if serviceName != "" {
+		tags[ServiceNameTagKey] = serviceName
+	}
+
+	if planName != "" {
+		tags[ServicePlanName] = planName
+	}
+
+	return tags, nil
+}
+
+// timestamp formats the current time for the action timestamp tags, using
+// the configured clock, layout and location. By default, it is the local time
+// formatted as RFC 3339.
+func (s tagSettings) timestamp() string {
+	var now time.Time
+	if s.clock != nil {
+		now = s.clock.Now()
+	} else {
+		now = time.Now()
+	}
+	if s.timestampLocation != nil {
+		now = now.In(s.timestampLocation)
+	}
+	layout := s.timestampLayout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return now.Format(layout)
+}
+
+// lookupContext derives the context used for a single CF API lookup, applying
+// the configured lookup timeout if there is one.
+func (t *CfTagManager) lookupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.lookupTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t.lookupTimeout)
+}
+
+func (t *CfTagManager) getServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	instance, err := t.cfResourceGetter.GetServiceInstance(ctx, instanceGUID)
+	if err != nil {
+		return nil, newLookupError(ServiceInstanceResource, instanceGUID, err)
+	}
+	return instance, nil
+}
+
+func (t *CfTagManager) getSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	space, err := t.cfResourceGetter.GetSpace(ctx, spaceGUID)
+	if err != nil {
+		return nil, newLookupError(SpaceResource, spaceGUID, err)
+	}
+	return space, nil
+}
+
+func (t *CfTagManager) getServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	includeGetter := t.cfResourceGetter.(ServiceInstanceIncludeGetter)
+	instance, space, organization, err := includeGetter.GetServiceInstanceIncludeSpaceAndOrganization(ctx, instanceGUID)
+	if err != nil {
+		return nil, nil, nil, newLookupError(ServiceInstanceResource, instanceGUID, err)
+	}
+	return instance, space, organization, nil
+}
+
+func (t *CfTagManager) getOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	ctx, cancel := t.lookupContext(ctx)
+	defer cancel()
+	organization, err := t.cfResourceGetter.GetOrganization(ctx, organizationGUID)
+	if err != nil {
+		return nil, newLookupError(OrganizationResource, organizationGUID, err)
+	}
+	return organization, nil
+}
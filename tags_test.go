@@ -1,8 +1,11 @@
 package brokertags
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 	"github.com/google/go-cmp/cmp"
@@ -20,9 +23,15 @@ type mockCFClientWrapper struct {
 	instanceGUID                string
 	getServiceInstanceCallCount int
 	getSpaceInstanceCallCount   int
+	getIncludesCallCount        int
+	getOrganizationCallCount    int
+	organizationMetadata        *resource.Metadata
+	spaceMetadata               *resource.Metadata
+	instanceMetadata            *resource.Metadata
 }
 
-func (m *mockCFClientWrapper) getOrganization(organizationGUID string) (*resource.Organization, error) {
+func (m *mockCFClientWrapper) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	m.getOrganizationCallCount++
 	if m.getOrganizationErr != nil {
 		return nil, m.getOrganizationErr
 	}
@@ -30,11 +39,12 @@ func (m *mockCFClientWrapper) getOrganization(organizationGUID string) (*resourc
 		return nil, errors.New("organization GUID does not match expected value")
 	}
 	return &resource.Organization{
-		Name: m.organizationName,
+		Name:     m.organizationName,
+		Metadata: m.organizationMetadata,
 	}, nil
 }
 
-func (m *mockCFClientWrapper) getSpace(spaceGUID string) (*resource.Space, error) {
+func (m *mockCFClientWrapper) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
 	m.getSpaceInstanceCallCount++
 	if m.getSpaceErr != nil {
 		return nil, m.getSpaceErr
@@ -51,10 +61,11 @@ func (m *mockCFClientWrapper) getSpace(spaceGUID string) (*resource.Space, error
 				},
 			},
 		},
+		Metadata: m.spaceMetadata,
 	}, nil
 }
 
-func (m *mockCFClientWrapper) getServiceInstance(instanceGUID string) (*resource.ServiceInstance, error) {
+func (m *mockCFClientWrapper) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
 	m.getServiceInstanceCallCount++
 	if m.getServiceInstanceErr != nil {
 		return nil, m.getServiceInstanceErr
@@ -71,9 +82,58 @@ func (m *mockCFClientWrapper) getServiceInstance(instanceGUID string) (*resource
 				},
 			},
 		},
+		Metadata: m.instanceMetadata,
 	}, nil
 }
 
+func (m *mockCFClientWrapper) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	m.getIncludesCallCount++
+	if m.getServiceInstanceErr != nil {
+		return nil, nil, nil, m.getServiceInstanceErr
+	}
+	if m.instanceGUID != "" && m.instanceGUID != instanceGUID {
+		return nil, nil, nil, errors.New("instance GUID does not match expected value")
+	}
+	instance := &resource.ServiceInstance{
+		Name: m.instanceName,
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{
+					GUID: m.spaceGUID,
+				},
+			},
+		},
+	}
+	var (
+		space        *resource.Space
+		organization *resource.Organization
+	)
+	if m.spaceGUID != "" {
+		space = &resource.Space{
+			Name: m.spaceName,
+			Relationships: &resource.SpaceRelationships{
+				Organization: &resource.ToOneRelationship{
+					Data: &resource.Relationship{
+						GUID: m.organizationGUID,
+					},
+				},
+			},
+		}
+	}
+	if m.organizationGUID != "" {
+		organization = &resource.Organization{
+			Name: m.organizationName,
+			Resource: resource.Resource{
+				GUID: m.organizationGUID,
+			},
+		}
+	}
+	return instance, space, organization, nil
+}
+
 func TestGenerateTags(t *testing.T) {
 	testCases := map[string]struct {
 		tagManager                          *CfTagManager
@@ -85,6 +145,7 @@ func TestGenerateTags(t *testing.T) {
 		getMissingResources                 bool
 		expectedGetServiceInstanceCallCount int
 		expectedGetSpaceInstanceCallCount   int
+		expectedGetIncludesCallCount        int
 	}{
 		"Create": {
 			action:              Create,
@@ -159,6 +220,43 @@ func TestGenerateTags(t *testing.T) {
 				"Space name":            "space-1",
 			},
 		},
+		"Delete": {
+			action:              Delete,
+			serviceOfferingName: "abc1",
+			servicePlanName:     "abc2",
+			resourceGUIDS: ResourceGUIDs{
+				OrganizationGUID: "abc3",
+				SpaceGUID:        "abc4",
+				InstanceGUID:     "abc5",
+			},
+			tagManager: &CfTagManager{
+				broker:      "AWS Broker",
+				environment: "testing",
+				cfResourceGetter: &mockCFClientWrapper{
+					organizationName: "org-1",
+					spaceName:        "space-1",
+					spaceGUID:        "abc4",
+					organizationGUID: "abc3",
+					instanceGUID:     "abc5",
+					instanceName:     "abc6",
+				},
+			},
+			expectedGetServiceInstanceCallCount: 1,
+			expectedGetSpaceInstanceCallCount:   1,
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"environment":           "testing",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Organization GUID":     "abc3",
+				"Space GUID":            "abc4",
+				"Instance GUID":         "abc5",
+				"Instance name":         "abc6",
+				"Organization name":     "org-1",
+				"Space name":            "space-1",
+			},
+		},
 		"create - no broker name": {
 			action:              Create,
 			serviceOfferingName: "abc1",
@@ -283,8 +381,9 @@ func TestGenerateTags(t *testing.T) {
 					instanceName:     "abc6",
 				},
 			},
-			expectedGetSpaceInstanceCallCount:   1,
-			expectedGetServiceInstanceCallCount: 1,
+			expectedGetSpaceInstanceCallCount:   0,
+			expectedGetServiceInstanceCallCount: 0,
+			expectedGetIncludesCallCount:        1,
 			expectedTags: map[string]string{
 				"client":                "Cloud Foundry",
 				"broker":                "AWS Broker",
@@ -318,8 +417,9 @@ func TestGenerateTags(t *testing.T) {
 					instanceName:     "abc6",
 				},
 			},
-			expectedGetSpaceInstanceCallCount:   1,
-			expectedGetServiceInstanceCallCount: 1,
+			expectedGetSpaceInstanceCallCount:   0,
+			expectedGetServiceInstanceCallCount: 0,
+			expectedGetIncludesCallCount:        1,
 			expectedTags: map[string]string{
 				"client":                "Cloud Foundry",
 				"broker":                "AWS Broker",
@@ -381,7 +481,8 @@ func TestGenerateTags(t *testing.T) {
 				},
 			},
 			expectedGetSpaceInstanceCallCount:   0,
-			expectedGetServiceInstanceCallCount: 1,
+			expectedGetServiceInstanceCallCount: 0,
+			expectedGetIncludesCallCount:        1,
 			expectedTags: map[string]string{
 				"client":                "Cloud Foundry",
 				"broker":                "AWS Broker",
@@ -407,7 +508,7 @@ func TestGenerateTags(t *testing.T) {
 				t.Fatalf("unexpected error: %s", err)
 			}
 
-			actionTagKey := test.action.getTagKey()
+			actionTagKey, _ := test.action.getTagKey()
 			if tags[actionTagKey] == "" {
 				t.Fatalf("Expected a value for %s tag", actionTagKey)
 			}
@@ -425,6 +526,9 @@ func TestGenerateTags(t *testing.T) {
 				if test.expectedGetSpaceInstanceCallCount != mockCfResourceGetter.getSpaceInstanceCallCount {
 					t.Fatalf("Expected %d calls to getSpace, got %d", test.expectedGetSpaceInstanceCallCount, mockCfResourceGetter.getSpaceInstanceCallCount)
 				}
+				if test.expectedGetIncludesCallCount != mockCfResourceGetter.getIncludesCallCount {
+					t.Fatalf("Expected %d calls to getServiceInstanceIncludeSpaceAndOrganization, got %d", test.expectedGetIncludesCallCount, mockCfResourceGetter.getIncludesCallCount)
+				}
 			}
 		})
 	}
@@ -441,7 +545,7 @@ func TestGenerateTagsHandleErrors(t *testing.T) {
 					getOrganizationErr: errors.New("error getting organization"),
 				},
 			},
-			expectedErr: errors.New("error getting organization"),
+			expectedErr: errors.New("error getting organization org-1: error getting organization"),
 		},
 		"error getting space": {
 			tagManager: &CfTagManager{
@@ -450,7 +554,7 @@ func TestGenerateTagsHandleErrors(t *testing.T) {
 					getSpaceErr: errors.New("error getting space"),
 				},
 			},
-			expectedErr: errors.New("error getting space"),
+			expectedErr: errors.New("error getting space space-1: error getting space"),
 		},
 	}
 
@@ -512,3 +616,439 @@ func TestGetOrganizationGuidFromSpace(t *testing.T) {
 		})
 	}
 }
+
+type blockingResourceGetter struct{}
+
+func (b *blockingResourceGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *blockingResourceGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *blockingResourceGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *blockingResourceGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	<-ctx.Done()
+	return nil, nil, nil, ctx.Err()
+}
+
+func TestGenerateTagsContext(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := map[string]struct {
+		ctx         context.Context
+		tagManager  *CfTagManager
+		expectedErr error
+	}{
+		"canceled context": {
+			ctx: canceledCtx,
+			tagManager: &CfTagManager{
+				cfResourceGetter: &blockingResourceGetter{},
+			},
+			expectedErr: context.Canceled,
+		},
+		"lookup timeout": {
+			ctx: context.Background(),
+			tagManager: &CfTagManager{
+				cfResourceGetter: &blockingResourceGetter{},
+				lookupTimeout:    time.Millisecond,
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := test.tagManager.GenerateTagsContext(
+				test.ctx,
+				Update,
+				"abc1",
+				"abc2",
+				ResourceGUIDs{
+					InstanceGUID: "instance-1",
+				},
+				false,
+			)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerateTagsTimestamp(t *testing.T) {
+	eastern, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("unexpected error loading location: %s", err)
+	}
+	clock := ClockFunc(func() time.Time {
+		return time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	})
+
+	testCases := map[string]struct {
+		opts              []Option
+		action            Action
+		expectedTagKey    string
+		expectedTimestamp string
+	}{
+		"default layout": {
+			opts:              []Option{WithClock(clock)},
+			action:            Create,
+			expectedTagKey:    "Created at",
+			expectedTimestamp: "2024-01-02T03:04:05Z",
+		},
+		"date only": {
+			opts:              []Option{WithClock(clock), WithTimestampLayout(time.DateOnly)},
+			action:            Update,
+			expectedTagKey:    "Updated at",
+			expectedTimestamp: "2024-01-02",
+		},
+		"location": {
+			opts:              []Option{WithClock(clock), WithTimestampLocation(eastern)},
+			action:            Delete,
+			expectedTagKey:    "Deleted at",
+			expectedTimestamp: "2024-01-01T22:04:05-05:00",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager := newCfTagManager("", "", &mockCFClientWrapper{}, newOptions(test.opts))
+			tags, err := tagManager.GenerateTags(test.action, "abc1", "abc2", ResourceGUIDs{}, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tags[test.expectedTagKey] != test.expectedTimestamp {
+				t.Errorf("expected %s tag: %s, got: %s", test.expectedTagKey, test.expectedTimestamp, tags[test.expectedTagKey])
+			}
+		})
+	}
+}
+
+func TestGenerateTagsBestEffort(t *testing.T) {
+	testCases := map[string]struct {
+		cfResourceGetter    *mockCFClientWrapper
+		resourceGUIDs       ResourceGUIDs
+		getMissingResources bool
+		expectedTags        map[string]string
+		expectedWarnings    []ResourceKind
+		expectedErr         error
+	}{
+		"all lookups succeed": {
+			cfResourceGetter: &mockCFClientWrapper{
+				organizationName: "org-1",
+				spaceName:        "space-1",
+			},
+			resourceGUIDs: ResourceGUIDs{
+				SpaceGUID:        "space-guid-1",
+				OrganizationGUID: "org-guid-1",
+			},
+			expectedTags: map[string]string{
+				BrokerTagKey:           "AWS Broker",
+				ClientTagKey:           "Cloud Foundry",
+				ServiceNameTagKey:      "abc1",
+				ServicePlanName:        "abc2",
+				SpaceGUIDTagKey:        "space-guid-1",
+				SpaceNameTagKey:        "space-1",
+				OrganizationGUIDTagKey: "org-guid-1",
+				OrganizationNameTagKey: "org-1",
+			},
+		},
+		"space lookup fails": {
+			cfResourceGetter: &mockCFClientWrapper{
+				organizationName: "org-1",
+				getSpaceErr:      resource.NewResourceNotFoundError(),
+			},
+			resourceGUIDs: ResourceGUIDs{
+				SpaceGUID:        "space-guid-1",
+				OrganizationGUID: "org-guid-1",
+			},
+			expectedTags: map[string]string{
+				BrokerTagKey:           "AWS Broker",
+				ClientTagKey:           "Cloud Foundry",
+				ServiceNameTagKey:      "abc1",
+				ServicePlanName:        "abc2",
+				SpaceGUIDTagKey:        "space-guid-1",
+				OrganizationGUIDTagKey: "org-guid-1",
+				OrganizationNameTagKey: "org-1",
+			},
+			expectedWarnings: []ResourceKind{SpaceResource},
+			expectedErr:      ErrNotFound,
+		},
+		"space and organization lookups fail": {
+			cfResourceGetter: &mockCFClientWrapper{
+				getSpaceErr:        errors.New("error getting space"),
+				getOrganizationErr: errors.New("error getting organization"),
+			},
+			resourceGUIDs: ResourceGUIDs{
+				SpaceGUID:        "space-guid-1",
+				OrganizationGUID: "org-guid-1",
+			},
+			expectedTags: map[string]string{
+				BrokerTagKey:           "AWS Broker",
+				ClientTagKey:           "Cloud Foundry",
+				ServiceNameTagKey:      "abc1",
+				ServicePlanName:        "abc2",
+				SpaceGUIDTagKey:        "space-guid-1",
+				OrganizationGUIDTagKey: "org-guid-1",
+			},
+			expectedWarnings: []ResourceKind{SpaceResource, OrganizationResource},
+		},
+		"instance lookup fails": {
+			cfResourceGetter: &mockCFClientWrapper{
+				getServiceInstanceErr: errors.New("error getting instance"),
+			},
+			resourceGUIDs: ResourceGUIDs{
+				InstanceGUID: "instance-guid-1",
+			},
+			getMissingResources: true,
+			expectedTags: map[string]string{
+				BrokerTagKey:              "AWS Broker",
+				ClientTagKey:              "Cloud Foundry",
+				ServiceNameTagKey:         "abc1",
+				ServicePlanName:           "abc2",
+				ServiceInstanceGUIDTagKey: "instance-guid-1",
+			},
+			expectedWarnings: []ResourceKind{ServiceInstanceResource},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tagManager := &CfTagManager{
+				broker:           "AWS Broker",
+				cfResourceGetter: test.cfResourceGetter,
+			}
+			tags, err := tagManager.GenerateTags(
+				Update,
+				"abc1",
+				"abc2",
+				test.resourceGUIDs,
+				test.getMissingResources,
+				WithBestEffort(),
+			)
+			delete(tags, "Updated at")
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+
+			if test.expectedWarnings == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var partialErr *PartialTagsError
+			if !errors.As(err, &partialErr) {
+				t.Fatalf("expected *PartialTagsError, got: %v", err)
+			}
+			var warnings []ResourceKind
+			for _, warning := range partialErr.Warnings {
+				warnings = append(warnings, warning.Kind)
+			}
+			if !cmp.Equal(warnings, test.expectedWarnings) {
+				t.Errorf(cmp.Diff(warnings, test.expectedWarnings))
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error to be %s, got: %s", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+// concurrencyTrackingGetter is a ResourceGetter whose lookups take a while,
+// and which tracks how many of them run at once.
+type concurrencyTrackingGetter struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *concurrencyTrackingGetter) track() {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+}
+
+func (c *concurrencyTrackingGetter) GetOrganization(ctx context.Context, organizationGUID string) (*resource.Organization, error) {
+	c.track()
+	return &resource.Organization{Name: "org-1"}, nil
+}
+
+func (c *concurrencyTrackingGetter) GetSpace(ctx context.Context, spaceGUID string) (*resource.Space, error) {
+	c.track()
+	return &resource.Space{Name: "space-1"}, nil
+}
+
+func (c *concurrencyTrackingGetter) GetServiceInstance(ctx context.Context, instanceGUID string) (*resource.ServiceInstance, error) {
+	c.track()
+	return &resource.ServiceInstance{Name: "instance-1"}, nil
+}
+
+func (c *concurrencyTrackingGetter) GetServiceInstanceIncludeSpaceAndOrganization(
+	ctx context.Context,
+	instanceGUID string,
+) (*resource.ServiceInstance, *resource.Space, *resource.Organization, error) {
+	c.track()
+	return &resource.ServiceInstance{Name: "instance-1"}, nil, nil, nil
+}
+
+func TestGenerateTagsConcurrentLookups(t *testing.T) {
+	testCases := map[string]struct {
+		opts                []Option
+		expectedMaxInFlight int
+	}{
+		"default": {
+			expectedMaxInFlight: 3,
+		},
+		"sequential": {
+			opts:                []Option{WithMaxConcurrentLookups(1)},
+			expectedMaxInFlight: 1,
+		},
+		"two at a time": {
+			opts:                []Option{WithMaxConcurrentLookups(2)},
+			expectedMaxInFlight: 2,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfResourceGetter := &concurrencyTrackingGetter{}
+			tagManager := newCfTagManager("AWS Broker", "testing", cfResourceGetter, newOptions(test.opts))
+			tags, err := tagManager.GenerateTags(
+				Update,
+				"abc1",
+				"abc2",
+				ResourceGUIDs{
+					InstanceGUID:     "instance-guid-1",
+					SpaceGUID:        "space-guid-1",
+					OrganizationGUID: "org-guid-1",
+				},
+				false,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tags[ServiceInstanceNameTagKey] != "instance-1" || tags[SpaceNameTagKey] != "space-1" || tags[OrganizationNameTagKey] != "org-1" {
+				t.Errorf("unexpected tags: %v", tags)
+			}
+			if cfResourceGetter.maxInFlight != test.expectedMaxInFlight {
+				t.Errorf("expected at most %d concurrent lookups, got: %d", test.expectedMaxInFlight, cfResourceGetter.maxInFlight)
+			}
+		})
+	}
+}
+
+func TestGenerateTagsCombinesLookupErrors(t *testing.T) {
+	spaceErr := errors.New("error getting space")
+	organizationErr := errors.New("error getting organization")
+	tagManager := &CfTagManager{
+		cfResourceGetter: &mockCFClientWrapper{
+			getServiceInstanceErr: errors.New("error getting instance"),
+			getSpaceErr:           spaceErr,
+			getOrganizationErr:    organizationErr,
+		},
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := tagManager.GenerateTags(
+			Update,
+			"abc1",
+			"abc2",
+			ResourceGUIDs{
+				InstanceGUID:     "instance-1",
+				SpaceGUID:        "space-1",
+				OrganizationGUID: "org-1",
+			},
+			false,
+		)
+		expectedErr := "error getting service instance instance-1: error getting instance\n" +
+			"error getting space space-1: error getting space\n" +
+			"error getting organization org-1: error getting organization"
+		if err == nil || err.Error() != expectedErr {
+			t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+		}
+		if !errors.Is(err, spaceErr) || !errors.Is(err, organizationErr) {
+			t.Errorf("expected error to wrap the space and organization errors, got: %s", err)
+		}
+	}
+}
+
+func TestGenerateTagsWithoutIncludes(t *testing.T) {
+	mockResourceGetter := &mockCFClientWrapper{
+		instanceName:     "instance-1",
+		spaceName:        "space-1",
+		organizationName: "org-1",
+		instanceGUID:     "instance-guid-1",
+		spaceGUID:        "space-guid-1",
+		organizationGUID: "org-guid-1",
+	}
+	// Embedding hides the GetServiceInstanceIncludeSpaceAndOrganization method
+	// of the mock, as an outside ResourceGetter may not have it.
+	tagManager, err := NewCFTagManager(
+		"",
+		"",
+		"",
+		"",
+		"",
+		WithResourceGetter(struct{ ResourceGetter }{mockResourceGetter}),
+		WithCache(time.Minute, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := tagManager.cfResourceGetter.(ServiceInstanceIncludeGetter); ok {
+		t.Fatal("expected the cache not to be a ServiceInstanceIncludeGetter")
+	}
+
+	tags, err := tagManager.GenerateTags(
+		Update,
+		"",
+		"",
+		ResourceGUIDs{InstanceGUID: "instance-guid-1"},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for key, expected := range map[string]string{
+		ServiceInstanceNameTagKey: "instance-1",
+		SpaceNameTagKey:           "space-1",
+		OrganizationNameTagKey:    "org-1",
+	} {
+		if tags[key] != expected {
+			t.Errorf("expected tag %q to be %q, got %q", key, expected, tags[key])
+		}
+	}
+	if mockResourceGetter.getIncludesCallCount != 0 ||
+		mockResourceGetter.getServiceInstanceCallCount != 1 ||
+		mockResourceGetter.getSpaceInstanceCallCount != 1 ||
+		mockResourceGetter.getOrganizationCallCount != 1 {
+		t.Errorf("expected one lookup of each resource without includes, got %d includes, %d instance, %d space and %d organization lookups",
+			mockResourceGetter.getIncludesCallCount,
+			mockResourceGetter.getServiceInstanceCallCount,
+			mockResourceGetter.getSpaceInstanceCallCount,
+			mockResourceGetter.getOrganizationCallCount,
+		)
+	}
+}
@@ -0,0 +1,124 @@
+package brokertags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// UserTagLimits are the limits that user tags must stay within.
+type UserTagLimits struct {
+	MaxTags        int
+	MaxKeyLength   int
+	MaxValueLength int
+}
+
+// DefaultUserTagLimits are the limits used for user tags unless others are
+// set with WithUserTagLimits. The key and value lengths match the AWS limits.
+var DefaultUserTagLimits = UserTagLimits{
+	MaxTags:        10,
+	MaxKeyLength:   128,
+	MaxValueLength: 256,
+}
+
+// UserTagProblem describes why a user tag is invalid. Key is empty for
+// problems that apply to the user tags as a whole.
+type UserTagProblem struct {
+	Key    string
+	Reason string
+}
+
+// UserTagsValidationError is returned when user tags are invalid. Its message
+// is suitable for returning to the user, for instance in the description of
+// an OSB 400 Bad Request response.
+type UserTagsValidationError struct {
+	Problems []UserTagProblem
+}
+
+func (e *UserTagsValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		if problem.Key == "" {
+			problems = append(problems, problem.Reason)
+		} else {
+			problems = append(problems, fmt.Sprintf("%q: %s", problem.Key, problem.Reason))
+		}
+	}
+	return "invalid tags: " + strings.Join(problems, "; ")
+}
+
+// ValidateUserTags checks that user tags stay within limits and do not use any
+// of the keys of the tags generated by this package, ignoring case. Any limit
+// left at zero uses the corresponding limit from DefaultUserTagLimits. If the
+// tags are invalid, a *UserTagsValidationError listing every problem is
+// returned.
+func ValidateUserTags(tags map[string]string, limits UserTagLimits) error {
+	return validateUserTags(tags, limits, nil)
+}
+
+// ValidateUserTags is like the ValidateUserTags function, using the user tag
+// limits of the CfTagManager. The keys of the tags that metadata is copied to,
+// if WithMetadataTags is set, are reserved too, so that users cannot override
+// metadata tags.
+func (t *CfTagManager) ValidateUserTags(tags map[string]string) error {
+	return validateUserTags(tags, t.userTagLimits, t.metadataTags)
+}
+
+func validateUserTags(tags map[string]string, limits UserTagLimits, metadataTags *MetadataTagConfig) error {
+	limits = limits.withDefaults()
+
+	var problems []UserTagProblem
+	if len(tags) > limits.MaxTags {
+		problems = append(problems, UserTagProblem{
+			Reason: fmt.Sprintf("at most %d tags are allowed, got %d", limits.MaxTags, len(tags)),
+		})
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case strings.TrimSpace(key) == "":
+			problems = append(problems, UserTagProblem{key, "key cannot be empty"})
+		case isReservedTagKey(key), metadataTags.reservesTagKey(key):
+			problems = append(problems, UserTagProblem{key, "key is reserved"})
+		case utf8.RuneCountInString(key) > limits.MaxKeyLength:
+			problems = append(problems, UserTagProblem{key, fmt.Sprintf("key is longer than %d characters", limits.MaxKeyLength)})
+		}
+		if utf8.RuneCountInString(tags[key]) > limits.MaxValueLength {
+			problems = append(problems, UserTagProblem{key, fmt.Sprintf("value is longer than %d characters", limits.MaxValueLength)})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &UserTagsValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (l UserTagLimits) withDefaults() UserTagLimits {
+	if l.MaxTags == 0 {
+		l.MaxTags = DefaultUserTagLimits.MaxTags
+	}
+	if l.MaxKeyLength == 0 {
+		l.MaxKeyLength = DefaultUserTagLimits.MaxKeyLength
+	}
+	if l.MaxValueLength == 0 {
+		l.MaxValueLength = DefaultUserTagLimits.MaxValueLength
+	}
+	return l
+}
+
+func isReservedTagKey(key string) bool {
+	for managedKey := range managedTagKeys {
+		if strings.EqualFold(key, managedKey) {
+			return true
+		}
+	}
+	return false
+}
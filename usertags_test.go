@@ -0,0 +1,193 @@
+package brokertags
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateUserTags(t *testing.T) {
+	testCases := map[string]struct {
+		tags             map[string]string
+		limits           UserTagLimits
+		expectedProblems []UserTagProblem
+	}{
+		"valid": {
+			tags: map[string]string{
+				"Cost center": "abc",
+				"Team":        "def",
+			},
+		},
+		"no tags": {},
+		"reserved keys": {
+			tags: map[string]string{
+				"Broker":            "abc",
+				"Organization GUID": "def",
+				"created at":        "ghi",
+			},
+			expectedProblems: []UserTagProblem{
+				{Key: "Broker", Reason: "key is reserved"},
+				{Key: "Organization GUID", Reason: "key is reserved"},
+				{Key: "created at", Reason: "key is reserved"},
+			},
+		},
+		"empty key": {
+			tags: map[string]string{
+				" ": "abc",
+			},
+			expectedProblems: []UserTagProblem{
+				{Key: " ", Reason: "key cannot be empty"},
+			},
+		},
+		"limits": {
+			tags: map[string]string{
+				"abcd": "ab",
+				"ab":   "abcd",
+				"abc":  "abc",
+			},
+			limits: UserTagLimits{
+				MaxTags:        2,
+				MaxKeyLength:   3,
+				MaxValueLength: 3,
+			},
+			expectedProblems: []UserTagProblem{
+				{Reason: "at most 2 tags are allowed, got 3"},
+				{Key: "ab", Reason: "value is longer than 3 characters"},
+				{Key: "abcd", Reason: "key is longer than 3 characters"},
+			},
+		},
+		"default limits": {
+			tags: map[string]string{
+				"Team": strings.Repeat("a", 257),
+			},
+			expectedProblems: []UserTagProblem{
+				{Key: "Team", Reason: "value is longer than 256 characters"},
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateUserTags(test.tags, test.limits)
+			if test.expectedProblems == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var validationErr *UserTagsValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got: %s", err)
+			}
+			if !cmp.Equal(validationErr.Problems, test.expectedProblems) {
+				t.Errorf(cmp.Diff(validationErr.Problems, test.expectedProblems))
+			}
+		})
+	}
+}
+
+func TestUserTagsValidationError(t *testing.T) {
+	err := &UserTagsValidationError{
+		Problems: []UserTagProblem{
+			{Reason: "at most 2 tags are allowed, got 3"},
+			{Key: "Broker", Reason: "key is reserved"},
+		},
+	}
+	expected := `invalid tags: at most 2 tags are allowed, got 3; "Broker": key is reserved`
+	if err.Error() != expected {
+		t.Errorf("expected error message: %s, got: %s", expected, err.Error())
+	}
+}
+
+func TestGenerateTagsWithUserTags(t *testing.T) {
+	testCases := map[string]struct {
+		tagManager   *CfTagManager
+		userTags     map[string]string
+		expectedTags map[string]string
+		expectedErr  error
+	}{
+		"user tags are merged": {
+			tagManager: &CfTagManager{
+				broker:           "AWS Broker",
+				cfResourceGetter: &mockCFClientWrapper{},
+			},
+			userTags: map[string]string{
+				"Cost center": "abc",
+			},
+			expectedTags: map[string]string{
+				"client":                "Cloud Foundry",
+				"broker":                "AWS Broker",
+				"Service offering name": "abc1",
+				"Service plan name":     "abc2",
+				"Cost center":           "abc",
+			},
+		},
+		"reserved keys are rejected": {
+			tagManager: &CfTagManager{
+				broker:           "AWS Broker",
+				cfResourceGetter: &mockCFClientWrapper{},
+			},
+			userTags: map[string]string{
+				"broker": "Other Broker",
+			},
+			expectedErr: errors.New(`invalid tags: "broker": key is reserved`),
+		},
+		"metadata tag keys are rejected": {
+			tagManager: &CfTagManager{
+				cfResourceGetter: &mockCFClientWrapper{},
+				metadataTags: &MetadataTagConfig{
+					Keys:       []string{"team", "cloud.gov/agency"},
+					Prefixes:   []string{"cloud.gov/"},
+					KeyMapping: map[string]string{"cloud.gov/agency": "Agency"},
+				},
+			},
+			userTags: map[string]string{
+				"agency":                "gsa",
+				"Cloud.gov/cost-center": "abc",
+				"Team":                  "def",
+				"cloud.gov/agency":      "gsa",
+			},
+			expectedErr: errors.New(`invalid tags: "Cloud.gov/cost-center": key is reserved; ` +
+				`"Team": key is reserved; "agency": key is reserved`),
+		},
+		"configured limits are used": {
+			tagManager: &CfTagManager{
+				cfResourceGetter: &mockCFClientWrapper{},
+				userTagLimits: UserTagLimits{
+					MaxTags: 1,
+				},
+			},
+			userTags: map[string]string{
+				"Cost center": "abc",
+				"Team":        "def",
+			},
+			expectedErr: errors.New("invalid tags: at most 1 tags are allowed, got 2"),
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags, err := test.tagManager.GenerateTags(
+				Create,
+				"abc1",
+				"abc2",
+				ResourceGUIDs{},
+				false,
+				WithUserTags(test.userTags),
+			)
+			if (test.expectedErr != nil && err == nil) ||
+				(err != nil && err.Error() != test.expectedErr.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if test.expectedErr != nil {
+				return
+			}
+			delete(tags, createdAtTagKey)
+			if !cmp.Equal(tags, test.expectedTags) {
+				t.Errorf(cmp.Diff(tags, test.expectedTags))
+			}
+		})
+	}
+}